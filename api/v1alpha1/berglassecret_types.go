@@ -17,53 +17,295 @@ limitations under the License.
 package v1alpha1
 
 import (
+	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 // BerglasSecretSpec defines the desired state of BerglasSecret
 type BerglasSecretSpec struct {
 	// Data is a map of key value pairs that will be stored in Secret.
-	Data map[string]string `json:"data"`
+	// +optional
+	Data map[string]string `json:"data,omitempty"`
+
+	// DataOptions configures how the resolved values of Data are stored, keyed by the key of Data.
+	// +optional
+	DataOptions map[string]DataOption `json:"dataOptions,omitempty"`
+
+	// DataFrom is a list of references whose payloads are parsed and expanded into Secret keys.
+	// +optional
+	DataFrom []DataFrom `json:"dataFrom,omitempty"`
+
+	// Template is a map of keys and Go text/template strings which will be rendered and stored in Secret.
+	// Templates refer to the resolved values of Data by the key, e.g. `{{ .password }}` or `{{ index . "tls.crt" }}`.
+	// b64enc, b64dec, toJson, indent, nindent and quote functions are available.
+	// +optional
+	Template map[string]string `json:"template,omitempty"`
+
+	// Type is the type of Secret, e.g. kubernetes.io/tls. Default value is Opaque.
+	// Because the type of Secret is immutable, Secret is recreated when it is changed.
+	// +optional
+	Type v1.SecretType `json:"type,omitempty"`
+
+	// DockerConfig builds the .dockerconfigjson key from the registry credentials.
+	// It requires the type to be kubernetes.io/dockerconfigjson.
+	// +optional
+	DockerConfig *DockerConfig `json:"dockerConfig,omitempty"`
+
+	// Target configures the metadata of the generated Secret.
+	// +optional
+	Target SecretTarget `json:"target,omitempty"`
+
+	// PartialSync writes the values which are resolved even if some of the others fail.
+	// The failed values keep the ones stored in Secret last time, and their errors are reported in status.keys.
+	// +optional
+	PartialSync bool `json:"partialSync,omitempty"`
+
+	// Suspend stops syncing Secret. Secret keeps the current contents until it is unset.
+	// +optional
+	Suspend bool `json:"suspend,omitempty"`
 
 	// RefreshInterval is the time interval to refresh the secret.
 	// Default value is 10m.
 	RefreshInterval *metav1.Duration `json:"refreshInterval,omitempty"`
 }
 
-type BerglasSecretConditionType string
+// DataOption configures how a resolved value is stored.
+// At most one of JSONPath and Path can be set.
+type DataOption struct {
+	// JSONPath extracts a field from the JSON or YAML payload with a JSONPath expression, e.g. {.password}.
+	// +optional
+	JSONPath string `json:"jsonPath,omitempty"`
+
+	// Path extracts a field from the JSON or YAML payload with a gjson style path, e.g. users.0.password.
+	// +optional
+	Path string `json:"path,omitempty"`
+
+	// Optional omits the key from Secret instead of failing when the reference is not found.
+	// +optional
+	Optional bool `json:"optional,omitempty"`
+}
+
+// DataFrom expands the top-level fields of a structured payload into Secret keys.
+type DataFrom struct {
+	// Ref is the reference of the payload, e.g. sm://project/credentials.
+	Ref string `json:"ref"`
+
+	// Format is the format of the payload. Default value is json.
+	// String fields are stored as is, and the other fields are stored as JSON.
+	// +kubebuilder:validation:Enum=json;yaml;dotenv
+	// +optional
+	Format string `json:"format,omitempty"`
+
+	// Prefix is added to each key.
+	// +optional
+	Prefix string `json:"prefix,omitempty"`
+
+	// Include is a list of glob patterns of the keys to be stored. All keys are stored when it is empty.
+	// +optional
+	Include []string `json:"include,omitempty"`
+
+	// Exclude is a list of glob patterns of the keys not to be stored.
+	// +optional
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+// SecretTarget configures the generated Secret.
+type SecretTarget struct {
+	// Name is the name of Secret. Default value is the name of BerglasSecret.
+	// When it is changed, the old Secret is deleted.
+	// +optional
+	Name string `json:"name,omitempty"`
+
+	// Labels are added to Secret.
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Annotations are added to Secret. Annotations with kitagry.github.io/ prefix are reserved for the controller.
+	// +optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+
+	// CreationPolicy is how the controller writes Secret. Default value is Owner.
+	// +optional
+	CreationPolicy CreationPolicy `json:"creationPolicy,omitempty"`
+
+	// Adopt takes over an existing Secret which isn't written by the controller.
+	// Without it, such a Secret is left as is and the Ready condition is False with AdoptionRefused reason.
+	// It is ignored when CreationPolicy is Merge.
+	// +optional
+	Adopt bool `json:"adopt,omitempty"`
+
+	// DeletionPolicy is what happens to Secret when the resource is deleted. Default value is Delete.
+	// It takes effect only when CreationPolicy is Owner.
+	// +optional
+	DeletionPolicy DeletionPolicy `json:"deletionPolicy,omitempty"`
+
+	// Immutable writes each revision of Secret as an immutable Secret named <name>-<hash>,
+	// where the hash is computed from the versions of the references and the spec.
+	// The current name is set to status.secretName. It requires CreationPolicy Owner,
+	// and it is not supported by ClusterBerglasSecret.
+	// +optional
+	Immutable bool `json:"immutable,omitempty"`
+
+	// RevisionHistoryLimit is the number of the old immutable Secrets kept for rollback. Default value is 3.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	RevisionHistoryLimit *int32 `json:"revisionHistoryLimit,omitempty"`
+}
+
+// DeletionPolicy is what happens to Secret when the resource is deleted.
+// +kubebuilder:validation:Enum=Delete;Retain
+type DeletionPolicy string
 
 const (
-	// Available means Secret which is related with BerglasSecret was created.
-	BerglasSecretAvailable BerglasSecretConditionType = "Available"
-	// Failure is added in a BerglasSecret when berglas cannot resolve berglas schema secret.
-	BerglasSecretFailure BerglasSecretConditionType = "Failure"
+	// DeletionPolicyDelete deletes Secret together with the resource by the garbage collector.
+	DeletionPolicyDelete DeletionPolicy = "Delete"
+	// DeletionPolicyRetain removes the owner reference from Secret before the resource is deleted,
+	// and labels it with OrphanedLabel.
+	DeletionPolicyRetain DeletionPolicy = "Retain"
 )
 
-type BerglasSecretCondition struct {
-	// Type of berglas secret condition.
-	Type BerglasSecretConditionType `json:"type"`
-	// Status of the condition, one of True, False, Unknown.
-	Status metav1.ConditionStatus `json:"status"`
-	// (brief) machine readable reason for the condition's last transition.
-	// +optional
-	Reason string `json:"reason,omitempty"`
-	// Human readable message indicating details about last transition.
+// OrphanedLabel is set to "true" on Secrets which are retained after the resource is deleted.
+// It is removed when the Secret is adopted again.
+const OrphanedLabel = "kitagry.github.io/orphaned"
+
+const (
+	// ReloadAnnotation is set to "true" on Deployments, StatefulSets and DaemonSets
+	// which are rolled out when the Secrets they reference are changed.
+	ReloadAnnotation = "berglas.kitagry.github.io/reload"
+
+	// ChecksumAnnotationPrefix is the prefix of the annotations set to the pod template of the workloads with ReloadAnnotation.
+	// Each referenced Secret has its own annotation, which is changed when the Secret is changed and triggers a rollout.
+	ChecksumAnnotationPrefix = "checksum.berglas.kitagry.github.io/"
+)
+
+// CreationPolicy is how the controller writes Secret.
+// +kubebuilder:validation:Enum=Owner;Merge;Orphan
+type CreationPolicy string
+
+const (
+	// CreationPolicyOwner creates Secret owned by the resource, so that it is deleted together.
+	CreationPolicyOwner CreationPolicy = "Owner"
+	// CreationPolicyMerge writes only the keys of the resource into an existing Secret, keeping the others.
+	// The Secret is not created, and its type is not changed.
+	CreationPolicyMerge CreationPolicy = "Merge"
+	// CreationPolicyOrphan creates Secret without the owner reference, so that it is kept after the resource is deleted.
+	CreationPolicyOrphan CreationPolicy = "Orphan"
+)
+
+// DockerConfig is the credentials of a container registry.
+// Each field accepts a reference or a literal value.
+type DockerConfig struct {
+	// Registry is the server of the registry, e.g. asia-northeast1-docker.pkg.dev.
+	Registry string `json:"registry"`
+	// Username is the username for the registry.
+	Username string `json:"username"`
+	// Password is the password for the registry.
+	Password string `json:"password"`
+	// Email is the email for the registry.
 	// +optional
-	Message string `json:"message,omitempty"`
+	Email string `json:"email,omitempty"`
 }
 
+const (
+	// ConditionTypeReady is True when Secret is synced with BerglasSecret.
+	ConditionTypeReady = "Ready"
+
+	// ReasonSynced is the reason of the Ready condition when Secret is synced.
+	ReasonSynced = "Synced"
+
+	// ForceSyncAnnotation requests an immediate sync when its value, such as a timestamp, is changed.
+	// The handled value is set to status.lastForceSyncToken.
+	ForceSyncAnnotation = "kitagry.github.io/force-sync"
+
+	// ConditionTypeSuspended is True when syncing is suspended by spec.suspend.
+	ConditionTypeSuspended = "Suspended"
+
+	// ReasonSuspended is the reason of the Suspended condition.
+	ReasonSuspended = "Suspended"
+)
+
 // BerglasSecretStatus defines the observed state of BerglasSecret
 type BerglasSecretStatus struct {
-	//+patchMergeKey=type
-	//+patchStrategy=merge
-	//+listType=map
-	//+listMapKey=type
-	Conditions []BerglasSecretCondition `json:"conditions,omitempty"`
+	// Conditions has the Ready condition.
+	// +patchMergeKey=type
+	// +patchStrategy=merge
+	// +listType=map
+	// +listMapKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// ObservedGeneration is the generation of BerglasSecret which was reconciled last.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// LastSyncTime is the time when Secret was synced successfully last.
+	// +optional
+	LastSyncTime *metav1.Time `json:"lastSyncTime,omitempty"`
+
+	// NextSyncTime is the time when the values will be refreshed next.
+	// +optional
+	NextSyncTime *metav1.Time `json:"nextSyncTime,omitempty"`
+
+	// SecretName is the name of Secret which is synced last.
+	// +optional
+	SecretName string `json:"secretName,omitempty"`
+
+	// LastForceSyncToken is the value of the force-sync annotation which was synced last.
+	// +optional
+	LastForceSyncToken string `json:"lastForceSyncToken,omitempty"`
+
+	// Keys is the status of each value of BerglasSecret.
+	// +listType=map
+	// +listMapKey=key
+	// +optional
+	Keys []KeyStatus `json:"keys,omitempty"`
+}
+
+// KeyStatus is the status of a value of BerglasSecret.
+type KeyStatus struct {
+	// Key is the key of spec.data, or dataFrom/<index> and dockerConfig/<field> for the other values.
+	Key string `json:"key"`
+
+	// Version is the version of the value stored in Secret. It is empty for literal values.
+	// +optional
+	Version string `json:"version,omitempty"`
+
+	// LastResolvedTime is the time when the value was last resolved or confirmed to be the latest version.
+	// +optional
+	LastResolvedTime *metav1.Time `json:"lastResolvedTime,omitempty"`
+
+	// VersionCreatedTime is the time when the version was created in the backend.
+	// It is set only for the backends which provide it, such as Secret Manager.
+	// +optional
+	VersionCreatedTime *metav1.Time `json:"versionCreatedTime,omitempty"`
+
+	// Reference is the reference whose version was created at VersionCreatedTime.
+	// It is set together with VersionCreatedTime, so that literal values are never stored.
+	// +optional
+	Reference string `json:"reference,omitempty"`
+
+	// PropagationLag is the time from the creation of the version to the update of Secret.
+	// It is set only when the same reference is rotated to a newer version.
+	// +optional
+	PropagationLag *metav1.Duration `json:"propagationLag,omitempty"`
+
+	// Message is a note about the value which is not an error, e.g. an optional value which is not found.
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// Error is the reason why the value couldn't be stored.
+	// +optional
+	Error string `json:"error,omitempty"`
 }
 
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
-// +kubebuilder:printcolumn:name="STATUS",type="string",JSONPath=".status.conditions[-1].type"
+// +kubebuilder:printcolumn:name="Ready",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].status"
+// +kubebuilder:printcolumn:name="Reason",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].reason"
+// +kubebuilder:printcolumn:name="Last Sync",type="date",JSONPath=".status.lastSyncTime"
+// +kubebuilder:printcolumn:name="Suspended",type="boolean",JSONPath=".spec.suspend",priority=1
+// +kubebuilder:printcolumn:name="Secret",type="string",JSONPath=".status.secretName",priority=1
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
 
 // BerglasSecret is the Schema for the berglassecrets API
 type BerglasSecret struct {
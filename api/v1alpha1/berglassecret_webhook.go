@@ -18,28 +18,44 @@ package v1alpha1
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"maps"
+	"path"
+	"slices"
+	"strings"
 
+	"go.opentelemetry.io/otel/attribute"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apivalidation "k8s.io/apimachinery/pkg/api/validation"
+	metav1validation "k8s.io/apimachinery/pkg/apis/meta/v1/validation"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/apimachinery/pkg/util/validation/field"
 	ctrl "sigs.k8s.io/controller-runtime"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 
-	"github.com/GoogleCloudPlatform/berglas/pkg/berglas"
-	myberglas "github.com/kitagry/berglas-secret-controller/internal/berglas"
+	"github.com/kitagry/berglas-secret-controller/internal/dataformat"
+	"github.com/kitagry/berglas-secret-controller/internal/provider"
+	"github.com/kitagry/berglas-secret-controller/internal/secrettemplate"
+	"github.com/kitagry/berglas-secret-controller/internal/tracing"
 )
 
 // log is for logging in this package.
 var berglassecretlog = logf.Log.WithName("berglassecret-resource")
 
-// SetupWebhookWithManager will setup the manager to manage the webhooks
-func (r *BerglasSecret) SetupWebhookWithManager(mgr ctrl.Manager) error {
+// SetupWebhookWithManager will setup the manager to manage the webhooks.
+// berglasClient is used to check that the references in BerglasSecret can be resolved.
+func (r *BerglasSecret) SetupWebhookWithManager(mgr ctrl.Manager, berglasClient berglasClient) error {
 	return ctrl.NewWebhookManagedBy(mgr).
 		For(r).
+		WithValidator(&berglasSecretValidator{berglasClient: berglasClient}).
 		Complete()
 }
 
@@ -47,79 +63,385 @@ func (r *BerglasSecret) SetupWebhookWithManager(mgr ctrl.Manager) error {
 // Modifying the path for an invalid path can cause API server errors; failing to locate the webhook.
 // +kubebuilder:webhook:path=/validate-batch-kitagry-github-io-v1alpha1-berglassecret,mutating=false,failurePolicy=fail,sideEffects=None,groups=batch.kitagry.github.io,resources=berglassecrets,verbs=create;update,versions=v1alpha1,name=vberglassecret.kb.io,admissionReviewVersions=v1
 
-var _ webhook.Validator = &BerglasSecret{}
+type berglasSecretValidator struct {
+	berglasClient berglasClient
+}
 
-// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
-func (r *BerglasSecret) ValidateCreate() (admission.Warnings, error) {
-	ctx := context.Background()
+var _ webhook.CustomValidator = &berglasSecretValidator{}
 
-	berglasClient, err := myberglas.New(ctx)
-	if err != nil {
-		berglassecretlog.Error(err, "failed to create berglas client")
-		return nil, nil
+// ValidateCreate implements webhook.CustomValidator so a webhook will be registered for the type
+func (v *berglasSecretValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	r, ok := obj.(*BerglasSecret)
+	if !ok {
+		return nil, fmt.Errorf("expected a BerglasSecret but got %T", obj)
 	}
+	berglassecretlog.V(1).Info("validate create", "name", r.Name)
 
-	return r.validate(ctx, berglasClient)
+	return r.validate(ctx, v.berglasClient)
 }
 
-// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
-func (r *BerglasSecret) ValidateUpdate(old runtime.Object) (admission.Warnings, error) {
-	oldBerglasSecret, ok := old.(*BerglasSecret)
+// ValidateUpdate implements webhook.CustomValidator so a webhook will be registered for the type
+func (v *berglasSecretValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	r, ok := newObj.(*BerglasSecret)
 	if !ok {
-		return nil, nil
+		return nil, fmt.Errorf("expected a BerglasSecret but got %T", newObj)
 	}
-
-	if maps.Equal(r.Spec.Data, oldBerglasSecret.Spec.Data) {
+	oldBerglasSecret, ok := oldObj.(*BerglasSecret)
+	if !ok {
 		return nil, nil
 	}
 
-	ctx := context.Background()
-
-	berglasClient, err := myberglas.New(ctx)
-	if err != nil {
-		berglassecretlog.Error(err, "failed to create berglas client")
+	// RefreshInterval and Suspend don't change the contents of Secret, so we don't need to resolve secrets again.
+	// Suspend must be changeable even when the references are broken.
+	newSpec, oldSpec := r.Spec.DeepCopy(), oldBerglasSecret.Spec.DeepCopy()
+	newSpec.RefreshInterval, oldSpec.RefreshInterval = nil, nil
+	newSpec.Suspend, oldSpec.Suspend = false, false
+	if equality.Semantic.DeepEqual(newSpec, oldSpec) {
 		return nil, nil
 	}
+	berglassecretlog.V(1).Info("validate update", "name", r.Name)
 
-	return r.validate(ctx, berglasClient)
+	warnings, err := r.validate(ctx, v.berglasClient)
+	if r.Spec.Type != oldBerglasSecret.Spec.Type {
+		warnings = append(warnings, "spec.type is changed, Secret will be deleted and created again because the type of Secret is immutable")
+	}
+	if r.Spec.Target.Name != oldBerglasSecret.Spec.Target.Name {
+		warnings = append(warnings, "spec.target.name is changed, the old Secret will be deleted")
+	}
+	return warnings, err
 }
 
-// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
-func (r *BerglasSecret) ValidateDelete() (admission.Warnings, error) {
+// ValidateDelete implements webhook.CustomValidator so a webhook will be registered for the type
+func (v *berglasSecretValidator) ValidateDelete(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
 	return nil, nil
 }
 
+// berglasClient resolves secret references. It is usually *provider.Registry.
+// Validate returns provider.ErrUnsupportedReference when the value is not a reference.
 type berglasClient interface {
 	Resolve(ctx context.Context, ref string) ([]byte, error)
+	Validate(ref string) error
 }
 
-func (r *BerglasSecret) validate(ctx context.Context, berglasClient berglasClient) (admission.Warnings, error) {
+func (r *BerglasSecret) validate(ctx context.Context, berglasClient berglasClient) (_ admission.Warnings, err error) {
+	ctx, span := tracing.Start(ctx, "BerglasSecret.validate", attribute.String("namespace", r.Namespace), attribute.String("name", r.Name))
+	defer func() { tracing.End(span, err) }()
+
+	warnings, allErrs := r.Spec.validate(provider.WithNamespace(ctx, r.Namespace), r.Name, berglasClient)
+	if len(allErrs) == 0 {
+		return warnings, nil
+	}
+
+	groupVersionKind := r.GroupVersionKind()
+	return warnings, apierrors.NewInvalid(
+		schema.GroupKind{Group: groupVersionKind.Group, Kind: groupVersionKind.Kind},
+		r.Name,
+		allErrs,
+	)
+}
+
+// validate checks that the values of spec can be resolved and the Secret can be built from them.
+// ctx has the namespace which requests the references, and name is the name of the object, which is the default name of Secret.
+// Optional references which are not found are reported as warnings.
+func (s *BerglasSecretSpec) validate(ctx context.Context, name string, berglasClient berglasClient) (admission.Warnings, field.ErrorList) {
+	var warnings admission.Warnings
 	var allErrs field.ErrorList
-	for key, secret := range r.Spec.Data {
-		ref, err := berglas.ParseReference(secret)
+	optionErrs := s.validateDataOptions()
+	allErrs = append(allErrs, optionErrs...)
+	for _, key := range slices.Sorted(maps.Keys(s.Data)) {
+		value, err := validateReference(ctx, berglasClient, "spec.data."+key, s.Data[key])
+		if err != nil && err.Type == field.ErrorTypeNotFound && s.DataOptions[key].Optional {
+			warnings = append(warnings, fmt.Sprintf("%s: %s, the key will be omitted from Secret", err.Field, err.Detail))
+			continue
+		}
 		if err != nil {
+			allErrs = append(allErrs, err)
+			continue
+		}
+
+		option, ok := s.DataOptions[key]
+		if !ok || len(optionErrs) > 0 {
+			continue
+		}
+		if _, err := dataformat.Extract(value, option.JSONPath, option.Path); err != nil {
+			allErrs = append(allErrs, field.Invalid(field.NewPath("spec", "dataOptions").Key(key), option, err.Error()))
+		}
+	}
+
+	if dc := s.DockerConfig; dc != nil {
+		for name, value := range map[string]string{"registry": dc.Registry, "username": dc.Username, "password": dc.Password, "email": dc.Email} {
+			if value == "" {
+				continue
+			}
+			if _, err := validateReference(ctx, berglasClient, "spec.dockerConfig."+name, value); err != nil {
+				allErrs = append(allErrs, err)
+			}
+		}
+	}
+
+	dataFromKeys, errs := s.validateDataFrom(ctx, berglasClient)
+	allErrs = append(allErrs, errs...)
+	templateWarnings, errs := s.validateTemplate(dataFromKeys)
+	warnings = append(warnings, templateWarnings...)
+	allErrs = append(allErrs, errs...)
+	allErrs = append(allErrs, s.validateType(dataFromKeys)...)
+	allErrs = append(allErrs, s.validateTarget(name)...)
+	return warnings, allErrs
+}
+
+// validateReference checks that value can be resolved when it is a reference, and returns the resolved value.
+func validateReference(ctx context.Context, berglasClient berglasClient, fieldPath, value string) ([]byte, *field.Error) {
+	err := berglasClient.Validate(value)
+	if errors.Is(err, provider.ErrUnsupportedReference) {
+		return []byte(value), nil
+	}
+	if err != nil {
+		return nil, &field.Error{
+			Type:     field.ErrorTypeInvalid,
+			Field:    fieldPath,
+			BadValue: value,
+			Detail:   err.Error(),
+		}
+	}
+	return resolveReference(ctx, berglasClient, fieldPath, value)
+}
+
+// resolveReference resolves the reference value.
+// A reference which is not found is reported as NotFound, and the other failures as InternalError.
+func resolveReference(ctx context.Context, berglasClient berglasClient, fieldPath, value string) ([]byte, *field.Error) {
+	ctx, span := tracing.Start(ctx, "validateReference", tracing.Key(fieldPath), tracing.ReferenceType(value))
+	resolved, err := berglasClient.Resolve(ctx, value)
+	tracing.End(span, err)
+	if errors.Is(err, provider.ErrNotFound) {
+		return nil, &field.Error{
+			Type:     field.ErrorTypeNotFound,
+			Field:    fieldPath,
+			BadValue: value,
+			Detail:   err.Error(),
+		}
+	}
+	if err != nil {
+		return nil, &field.Error{
+			Type:   field.ErrorTypeInternal,
+			Field:  fieldPath,
+			Detail: err.Error(),
+		}
+	}
+	return resolved, nil
+}
+
+func (s *BerglasSecretSpec) validateDataOptions() field.ErrorList {
+	var allErrs field.ErrorList
+	for key, option := range s.DataOptions {
+		fldPath := field.NewPath("spec", "dataOptions").Key(key)
+		if _, ok := s.Data[key]; !ok {
+			allErrs = append(allErrs, field.Invalid(fldPath, key, "the key is not defined in spec.data"))
+		}
+		if option.JSONPath != "" && option.Path != "" {
+			allErrs = append(allErrs, field.Invalid(fldPath, option, "only one of jsonPath and path can be set"))
+		}
+		if option.JSONPath != "" {
+			if err := dataformat.ValidateJSONPath(option.JSONPath); err != nil {
+				allErrs = append(allErrs, field.Invalid(fldPath.Child("jsonPath"), option.JSONPath, err.Error()))
+			}
+		}
+	}
+	return allErrs
+}
+
+// validateDataFrom resolves and expands spec.dataFrom, and returns the expanded keys.
+func (s *BerglasSecretSpec) validateDataFrom(ctx context.Context, berglasClient berglasClient) (sets.Set[string], field.ErrorList) {
+	var allErrs field.ErrorList
+	keys := sets.New[string]()
+	for i, df := range s.DataFrom {
+		fldPath := field.NewPath("spec", "dataFrom").Index(i)
+
+		var patternErrs field.ErrorList
+		for j, pattern := range df.Include {
+			if _, err := path.Match(pattern, ""); err != nil {
+				patternErrs = append(patternErrs, field.Invalid(fldPath.Child("include").Index(j), pattern, err.Error()))
+			}
+		}
+		for j, pattern := range df.Exclude {
+			if _, err := path.Match(pattern, ""); err != nil {
+				patternErrs = append(patternErrs, field.Invalid(fldPath.Child("exclude").Index(j), pattern, err.Error()))
+			}
+		}
+		if len(patternErrs) > 0 {
+			allErrs = append(allErrs, patternErrs...)
 			continue
 		}
 
-		_, err = berglasClient.Resolve(ctx, ref.String())
+		if err := berglasClient.Validate(df.Ref); err != nil {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("ref"), df.Ref, err.Error()))
+			continue
+		}
+		payload, fieldErr := resolveReference(ctx, berglasClient, fldPath.Child("ref").String(), df.Ref)
+		if fieldErr != nil {
+			allErrs = append(allErrs, fieldErr)
+			continue
+		}
+		fields, err := dataformat.Expand(df.Format, payload)
+		if err != nil {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("ref"), df.Ref, err.Error()))
+			continue
+		}
+		fields, err = dataformat.Filter(fields, df.Prefix, df.Include, df.Exclude)
 		if err != nil {
+			allErrs = append(allErrs, field.Invalid(fldPath, df.Ref, err.Error()))
+			continue
+		}
+
+		for _, key := range slices.Sorted(maps.Keys(fields)) {
+			for _, msg := range validation.IsConfigMapKey(key) {
+				allErrs = append(allErrs, field.Invalid(fldPath, key, msg))
+			}
+			if _, ok := s.Data[key]; ok {
+				allErrs = append(allErrs, field.Duplicate(fldPath, key))
+				continue
+			}
+			if keys.Has(key) {
+				allErrs = append(allErrs, field.Duplicate(fldPath, key))
+				continue
+			}
+			keys.Insert(key)
+		}
+	}
+	return keys, allErrs
+}
+
+// validateTemplate checks that the templates only refer to the keys of spec.data and spec.dataFrom.
+// Templates which refer to optional keys are reported as warnings, because the keys may be omitted.
+func (s *BerglasSecretSpec) validateTemplate(dataFromKeys sets.Set[string]) (admission.Warnings, field.ErrorList) {
+	var warnings admission.Warnings
+	var allErrs field.ErrorList
+	keys := slices.Collect(maps.Keys(s.Data))
+	keys = append(keys, dataFromKeys.UnsortedList()...)
+	for _, key := range slices.Sorted(maps.Keys(s.Template)) {
+		text := s.Template[key]
+		if _, ok := s.Data[key]; ok {
 			allErrs = append(allErrs, &field.Error{
-				Type:     field.ErrorTypeNotFound,
-				Field:    "spec.data." + key,
-				BadValue: secret,
+				Type:     field.ErrorTypeDuplicate,
+				Field:    "spec.template." + key,
+				BadValue: key,
+				Detail:   "the key is already defined in spec.data",
+			})
+			continue
+		}
+		if dataFromKeys.Has(key) {
+			allErrs = append(allErrs, &field.Error{
+				Type:     field.ErrorTypeDuplicate,
+				Field:    "spec.template." + key,
+				BadValue: key,
+				Detail:   "the key is already defined in spec.dataFrom",
+			})
+			continue
+		}
+
+		refs, err := secrettemplate.Validate(text, keys)
+		if err != nil {
+			allErrs = append(allErrs, &field.Error{
+				Type:     field.ErrorTypeInvalid,
+				Field:    "spec.template." + key,
+				BadValue: text,
 				Detail:   err.Error(),
 			})
+			continue
+		}
+		for _, ref := range refs {
+			if s.DataOptions[ref].Optional {
+				warnings = append(warnings, fmt.Sprintf("spec.template.%s: refers to the optional key %q, the template fails to render when the key is omitted", key, ref))
+			}
 		}
 	}
+	return warnings, allErrs
+}
 
-	if len(allErrs) == 0 {
-		return nil, nil
+// requiredKeys is the keys which Secret of the type must have.
+var requiredKeys = map[v1.SecretType][]string{
+	v1.SecretTypeTLS:              {v1.TLSCertKey, v1.TLSPrivateKeyKey},
+	v1.SecretTypeDockerConfigJson: {v1.DockerConfigJsonKey},
+	v1.SecretTypeDockercfg:        {v1.DockerConfigKey},
+	v1.SecretTypeSSHAuth:          {v1.SSHAuthPrivateKey},
+}
+
+func (s *BerglasSecretSpec) validateType(dataFromKeys sets.Set[string]) field.ErrorList {
+	var allErrs field.ErrorList
+	if s.DockerConfig != nil && s.Type != v1.SecretTypeDockerConfigJson {
+		allErrs = append(allErrs, &field.Error{
+			Type:     field.ErrorTypeInvalid,
+			Field:    "spec.type",
+			BadValue: s.Type,
+			Detail:   fmt.Sprintf("spec.dockerConfig requires type %s", v1.SecretTypeDockerConfigJson),
+		})
 	}
 
-	groupVersionKind := r.GroupVersionKind()
-	return nil, apierrors.NewInvalid(
-		schema.GroupKind{Group: groupVersionKind.Group, Kind: groupVersionKind.Kind},
-		r.Name,
-		allErrs,
-	)
+	keys := s.secretKeys().Union(dataFromKeys)
+	for _, key := range requiredKeys[s.Type] {
+		if !keys.Has(key) {
+			allErrs = append(allErrs, &field.Error{
+				Type:     field.ErrorTypeRequired,
+				Field:    "spec.data." + key,
+				BadValue: "",
+				Detail:   fmt.Sprintf("%s is required for type %s", key, s.Type),
+			})
+		}
+	}
+	if s.Type == v1.SecretTypeBasicAuth && !keys.Has(v1.BasicAuthUsernameKey) && !keys.Has(v1.BasicAuthPasswordKey) {
+		allErrs = append(allErrs, &field.Error{
+			Type:     field.ErrorTypeRequired,
+			Field:    "spec.data",
+			BadValue: "",
+			Detail:   fmt.Sprintf("%s or %s is required for type %s", v1.BasicAuthUsernameKey, v1.BasicAuthPasswordKey, s.Type),
+		})
+	}
+	return allErrs
+}
+
+// secretKeys returns the keys of Secret which are known before resolving references.
+func (s *BerglasSecretSpec) secretKeys() sets.Set[string] {
+	keys := sets.KeySet(s.Data).Union(sets.KeySet(s.Template))
+	if s.DockerConfig != nil {
+		keys.Insert(v1.DockerConfigJsonKey)
+	}
+	return keys
+}
+
+// reservedAnnotationPrefix is the prefix of annotations which are written by the controller.
+const reservedAnnotationPrefix = "kitagry.github.io/"
+
+// revisionSuffixLength is the length of "-<hash>" which the controller appends to the names of immutable Secrets.
+const revisionSuffixLength = 11
+
+// validateTarget checks spec.target. name is the name of the object, which is used when spec.target.name is empty.
+func (s *BerglasSecretSpec) validateTarget(name string) field.ErrorList {
+	target := s.Target
+	fldPath := field.NewPath("spec", "target")
+
+	var allErrs field.ErrorList
+	if target.Name != "" {
+		for _, msg := range validation.IsDNS1123Subdomain(target.Name) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("name"), target.Name, msg))
+		}
+	}
+	allErrs = append(allErrs, metav1validation.ValidateLabels(target.Labels, fldPath.Child("labels"))...)
+	allErrs = append(allErrs, apivalidation.ValidateAnnotations(target.Annotations, fldPath.Child("annotations"))...)
+	for key := range target.Annotations {
+		if strings.HasPrefix(key, reservedAnnotationPrefix) {
+			allErrs = append(allErrs, field.Forbidden(fldPath.Child("annotations").Key(key), "the annotation is reserved for the controller"))
+		}
+	}
+	if target.Immutable && target.CreationPolicy != "" && target.CreationPolicy != CreationPolicyOwner {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("immutable"), target.Immutable, "immutable requires creationPolicy Owner"))
+	}
+	if target.Immutable {
+		secretName, namePath := target.Name, fldPath.Child("name")
+		if secretName == "" {
+			secretName, namePath = name, field.NewPath("metadata", "name")
+		}
+		if len(secretName) > validation.DNS1123SubdomainMaxLength-revisionSuffixLength {
+			allErrs = append(allErrs, field.TooLong(namePath, secretName, validation.DNS1123SubdomainMaxLength-revisionSuffixLength))
+		}
+	}
+	return allErrs
 }
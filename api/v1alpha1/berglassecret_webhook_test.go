@@ -19,11 +19,16 @@ package v1alpha1
 import (
 	"context"
 	"errors"
+	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
 	mock_v1alpha1 "github.com/kitagry/berglas-secret-controller/api/v1alpha1/mock"
+	"github.com/kitagry/berglas-secret-controller/internal/provider"
 	"go.uber.org/mock/gomock"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 )
 
@@ -39,6 +44,7 @@ func TestBerglasSecret_validate(t *testing.T) {
 		"don't validate not berglas secret": {
 			createMockBerglasSecretClient: func(ctrl *gomock.Controller) berglasClient {
 				client := mock_v1alpha1.NewMockberglasClient(ctrl)
+				client.EXPECT().Validate("data").Return(provider.ErrUnsupportedReference)
 				return client
 			},
 			berglasSecret: &BerglasSecret{
@@ -53,6 +59,7 @@ func TestBerglasSecret_validate(t *testing.T) {
 		"don't return error when secret exists": {
 			createMockBerglasSecretClient: func(ctrl *gomock.Controller) berglasClient {
 				client := mock_v1alpha1.NewMockberglasClient(ctrl)
+				client.EXPECT().Validate("berglas://storage/secret").Return(nil)
 				client.EXPECT().Resolve(gomock.Any(), "berglas://storage/secret").Return([]byte("secret"), nil)
 				return client
 			},
@@ -68,6 +75,7 @@ func TestBerglasSecret_validate(t *testing.T) {
 		"return error when secret does not exist": {
 			createMockBerglasSecretClient: func(ctrl *gomock.Controller) berglasClient {
 				client := mock_v1alpha1.NewMockberglasClient(ctrl)
+				client.EXPECT().Validate("berglas://storage/secret").Return(nil)
 				client.EXPECT().Resolve(gomock.Any(), "berglas://storage/secret").Return(nil, errNotFound)
 				return client
 			},
@@ -81,6 +89,442 @@ func TestBerglasSecret_validate(t *testing.T) {
 			expectedWarnings: nil,
 			expectedError:    true,
 		},
+		"return warning when optional secret does not exist": {
+			createMockBerglasSecretClient: func(ctrl *gomock.Controller) berglasClient {
+				client := mock_v1alpha1.NewMockberglasClient(ctrl)
+				client.EXPECT().Validate("sm://project/flag").Return(nil)
+				client.EXPECT().Resolve(gomock.Any(), "sm://project/flag").Return(nil, provider.NotFound(errors.New("secret does not exist")))
+				return client
+			},
+			berglasSecret: &BerglasSecret{
+				Spec: BerglasSecretSpec{
+					Data: map[string]string{
+						"flag": "sm://project/flag",
+					},
+					DataOptions: map[string]DataOption{
+						"flag": {Optional: true},
+					},
+				},
+			},
+			expectedWarnings: admission.Warnings{"spec.data.flag: secret does not exist, the key will be omitted from Secret"},
+		},
+		"return error when optional secret cannot be resolved for other reasons": {
+			createMockBerglasSecretClient: func(ctrl *gomock.Controller) berglasClient {
+				client := mock_v1alpha1.NewMockberglasClient(ctrl)
+				client.EXPECT().Validate("sm://project/flag").Return(nil)
+				client.EXPECT().Resolve(gomock.Any(), "sm://project/flag").Return(nil, errors.New("permission denied"))
+				return client
+			},
+			berglasSecret: &BerglasSecret{
+				Spec: BerglasSecretSpec{
+					Data: map[string]string{
+						"flag": "sm://project/flag",
+					},
+					DataOptions: map[string]DataOption{
+						"flag": {Optional: true},
+					},
+				},
+			},
+			expectedWarnings: nil,
+			expectedError:    true,
+		},
+		"return error when reference is malformed": {
+			createMockBerglasSecretClient: func(ctrl *gomock.Controller) berglasClient {
+				client := mock_v1alpha1.NewMockberglasClient(ctrl)
+				client.EXPECT().Validate("sm://project").Return(errors.New("invalid reference"))
+				return client
+			},
+			berglasSecret: &BerglasSecret{
+				Spec: BerglasSecretSpec{
+					Data: map[string]string{
+						"some": "sm://project",
+					},
+				},
+			},
+			expectedWarnings: nil,
+			expectedError:    true,
+		},
+		"don't return error when template refers to data": {
+			createMockBerglasSecretClient: func(ctrl *gomock.Controller) berglasClient {
+				client := mock_v1alpha1.NewMockberglasClient(ctrl)
+				client.EXPECT().Validate("admin").Return(provider.ErrUnsupportedReference)
+				client.EXPECT().Validate("berglas://storage/secret").Return(nil)
+				client.EXPECT().Resolve(gomock.Any(), "berglas://storage/secret").Return([]byte("secret"), nil)
+				return client
+			},
+			berglasSecret: &BerglasSecret{
+				Spec: BerglasSecretSpec{
+					Data: map[string]string{
+						"user":     "admin",
+						"password": "berglas://storage/secret",
+					},
+					Template: map[string]string{
+						"url": "postgres://{{ .user }}:{{ .password }}@localhost:5432/app",
+					},
+				},
+			},
+			expectedWarnings: nil,
+		},
+		"return error when template refers to unknown key": {
+			createMockBerglasSecretClient: func(ctrl *gomock.Controller) berglasClient {
+				client := mock_v1alpha1.NewMockberglasClient(ctrl)
+				client.EXPECT().Validate("admin").Return(provider.ErrUnsupportedReference)
+				return client
+			},
+			berglasSecret: &BerglasSecret{
+				Spec: BerglasSecretSpec{
+					Data: map[string]string{
+						"user": "admin",
+					},
+					Template: map[string]string{
+						"url": "postgres://{{ .user }}:{{ .password }}@localhost:5432/app",
+					},
+				},
+			},
+			expectedWarnings: nil,
+			expectedError:    true,
+		},
+		"return warning when template refers to optional key": {
+			createMockBerglasSecretClient: func(ctrl *gomock.Controller) berglasClient {
+				client := mock_v1alpha1.NewMockberglasClient(ctrl)
+				client.EXPECT().Validate("sm://project/flag").Return(nil)
+				client.EXPECT().Resolve(gomock.Any(), "sm://project/flag").Return([]byte("on"), nil)
+				return client
+			},
+			berglasSecret: &BerglasSecret{
+				Spec: BerglasSecretSpec{
+					Data: map[string]string{
+						"flag": "sm://project/flag",
+					},
+					DataOptions: map[string]DataOption{
+						"flag": {Optional: true},
+					},
+					Template: map[string]string{
+						"config": "flag={{ .flag }}",
+					},
+				},
+			},
+			expectedWarnings: admission.Warnings{`spec.template.config: refers to the optional key "flag", the template fails to render when the key is omitted`},
+		},
+		"return error when template doesn't parse": {
+			createMockBerglasSecretClient: func(ctrl *gomock.Controller) berglasClient {
+				return mock_v1alpha1.NewMockberglasClient(ctrl)
+			},
+			berglasSecret: &BerglasSecret{
+				Spec: BerglasSecretSpec{
+					Template: map[string]string{
+						"url": "{{ .user ",
+					},
+				},
+			},
+			expectedWarnings: nil,
+			expectedError:    true,
+		},
+		"return error when template key conflicts with data": {
+			createMockBerglasSecretClient: func(ctrl *gomock.Controller) berglasClient {
+				client := mock_v1alpha1.NewMockberglasClient(ctrl)
+				client.EXPECT().Validate("admin").Return(provider.ErrUnsupportedReference)
+				return client
+			},
+			berglasSecret: &BerglasSecret{
+				Spec: BerglasSecretSpec{
+					Data: map[string]string{
+						"user": "admin",
+					},
+					Template: map[string]string{
+						"user": "{{ .user }}",
+					},
+				},
+			},
+			expectedWarnings: nil,
+			expectedError:    true,
+		},
+		"return error when required key of the type is missing": {
+			createMockBerglasSecretClient: func(ctrl *gomock.Controller) berglasClient {
+				client := mock_v1alpha1.NewMockberglasClient(ctrl)
+				client.EXPECT().Validate("berglas://storage/cert").Return(nil)
+				client.EXPECT().Resolve(gomock.Any(), "berglas://storage/cert").Return([]byte("cert"), nil)
+				return client
+			},
+			berglasSecret: &BerglasSecret{
+				Spec: BerglasSecretSpec{
+					Type: v1.SecretTypeTLS,
+					Data: map[string]string{
+						"tls.crt": "berglas://storage/cert",
+					},
+				},
+			},
+			expectedWarnings: nil,
+			expectedError:    true,
+		},
+		"don't return error when dockerConfig builds .dockerconfigjson": {
+			createMockBerglasSecretClient: func(ctrl *gomock.Controller) berglasClient {
+				client := mock_v1alpha1.NewMockberglasClient(ctrl)
+				client.EXPECT().Validate("asia-docker.pkg.dev").Return(provider.ErrUnsupportedReference)
+				client.EXPECT().Validate("_json_key").Return(provider.ErrUnsupportedReference)
+				client.EXPECT().Validate("sm://project/key").Return(nil)
+				client.EXPECT().Resolve(gomock.Any(), "sm://project/key").Return([]byte("key"), nil)
+				return client
+			},
+			berglasSecret: &BerglasSecret{
+				Spec: BerglasSecretSpec{
+					Type: v1.SecretTypeDockerConfigJson,
+					DockerConfig: &DockerConfig{
+						Registry: "asia-docker.pkg.dev",
+						Username: "_json_key",
+						Password: "sm://project/key",
+					},
+				},
+			},
+			expectedWarnings: nil,
+		},
+		"return error when dockerConfig is used with other type": {
+			createMockBerglasSecretClient: func(ctrl *gomock.Controller) berglasClient {
+				client := mock_v1alpha1.NewMockberglasClient(ctrl)
+				client.EXPECT().Validate(gomock.Any()).Return(provider.ErrUnsupportedReference).Times(3)
+				return client
+			},
+			berglasSecret: &BerglasSecret{
+				Spec: BerglasSecretSpec{
+					DockerConfig: &DockerConfig{
+						Registry: "asia-docker.pkg.dev",
+						Username: "user",
+						Password: "password",
+					},
+				},
+			},
+			expectedWarnings: nil,
+			expectedError:    true,
+		},
+		"don't return error when template refers to dataFrom keys": {
+			createMockBerglasSecretClient: func(ctrl *gomock.Controller) berglasClient {
+				client := mock_v1alpha1.NewMockberglasClient(ctrl)
+				client.EXPECT().Validate("sm://project/db").Return(nil)
+				client.EXPECT().Resolve(gomock.Any(), "sm://project/db").Return([]byte(`{"user":"admin","password":"p@ss"}`), nil)
+				return client
+			},
+			berglasSecret: &BerglasSecret{
+				Spec: BerglasSecretSpec{
+					DataFrom: []DataFrom{
+						{Ref: "sm://project/db", Prefix: "db_"},
+					},
+					Template: map[string]string{
+						"url": "postgres://{{ .db_user }}:{{ .db_password }}@localhost:5432/app",
+					},
+				},
+			},
+			expectedWarnings: nil,
+		},
+		"return error when dataFrom key conflicts with data": {
+			createMockBerglasSecretClient: func(ctrl *gomock.Controller) berglasClient {
+				client := mock_v1alpha1.NewMockberglasClient(ctrl)
+				client.EXPECT().Validate("admin").Return(provider.ErrUnsupportedReference)
+				client.EXPECT().Validate("sm://project/db").Return(nil)
+				client.EXPECT().Resolve(gomock.Any(), "sm://project/db").Return([]byte("user: admin\npassword: p@ss\n"), nil)
+				return client
+			},
+			berglasSecret: &BerglasSecret{
+				Spec: BerglasSecretSpec{
+					Data: map[string]string{
+						"user": "admin",
+					},
+					DataFrom: []DataFrom{
+						{Ref: "sm://project/db", Format: "yaml"},
+					},
+				},
+			},
+			expectedWarnings: nil,
+			expectedError:    true,
+		},
+		"return error when dataFrom payload doesn't parse": {
+			createMockBerglasSecretClient: func(ctrl *gomock.Controller) berglasClient {
+				client := mock_v1alpha1.NewMockberglasClient(ctrl)
+				client.EXPECT().Validate("sm://project/db").Return(nil)
+				client.EXPECT().Resolve(gomock.Any(), "sm://project/db").Return([]byte("not json"), nil)
+				return client
+			},
+			berglasSecret: &BerglasSecret{
+				Spec: BerglasSecretSpec{
+					DataFrom: []DataFrom{
+						{Ref: "sm://project/db"},
+					},
+				},
+			},
+			expectedWarnings: nil,
+			expectedError:    true,
+		},
+		"return error when dataFrom ref is not a reference": {
+			createMockBerglasSecretClient: func(ctrl *gomock.Controller) berglasClient {
+				client := mock_v1alpha1.NewMockberglasClient(ctrl)
+				client.EXPECT().Validate("literal").Return(provider.ErrUnsupportedReference)
+				return client
+			},
+			berglasSecret: &BerglasSecret{
+				Spec: BerglasSecretSpec{
+					DataFrom: []DataFrom{
+						{Ref: "literal"},
+					},
+				},
+			},
+			expectedWarnings: nil,
+			expectedError:    true,
+		},
+		"don't return error when the field can be extracted": {
+			createMockBerglasSecretClient: func(ctrl *gomock.Controller) berglasClient {
+				client := mock_v1alpha1.NewMockberglasClient(ctrl)
+				client.EXPECT().Validate("sm://project/db").Return(nil)
+				client.EXPECT().Resolve(gomock.Any(), "sm://project/db").Return([]byte(`{"password":"p@ss"}`), nil)
+				return client
+			},
+			berglasSecret: &BerglasSecret{
+				Spec: BerglasSecretSpec{
+					Data: map[string]string{
+						"password": "sm://project/db",
+					},
+					DataOptions: map[string]DataOption{
+						"password": {JSONPath: "{.password}"},
+					},
+				},
+			},
+			expectedWarnings: nil,
+		},
+		"return error when the field cannot be extracted": {
+			createMockBerglasSecretClient: func(ctrl *gomock.Controller) berglasClient {
+				client := mock_v1alpha1.NewMockberglasClient(ctrl)
+				client.EXPECT().Validate("sm://project/db").Return(nil)
+				client.EXPECT().Resolve(gomock.Any(), "sm://project/db").Return([]byte(`{"password":"p@ss"}`), nil)
+				return client
+			},
+			berglasSecret: &BerglasSecret{
+				Spec: BerglasSecretSpec{
+					Data: map[string]string{
+						"password": "sm://project/db",
+					},
+					DataOptions: map[string]DataOption{
+						"password": {Path: "credentials.password"},
+					},
+				},
+			},
+			expectedWarnings: nil,
+			expectedError:    true,
+		},
+		"return error when dataOptions refers to unknown key": {
+			createMockBerglasSecretClient: func(ctrl *gomock.Controller) berglasClient {
+				return mock_v1alpha1.NewMockberglasClient(ctrl)
+			},
+			berglasSecret: &BerglasSecret{
+				Spec: BerglasSecretSpec{
+					DataOptions: map[string]DataOption{
+						"password": {Path: "password"},
+					},
+				},
+			},
+			expectedWarnings: nil,
+			expectedError:    true,
+		},
+		"return error when both jsonPath and path are set": {
+			createMockBerglasSecretClient: func(ctrl *gomock.Controller) berglasClient {
+				client := mock_v1alpha1.NewMockberglasClient(ctrl)
+				client.EXPECT().Validate("sm://project/db").Return(nil)
+				client.EXPECT().Resolve(gomock.Any(), "sm://project/db").Return([]byte(`{"password":"p@ss"}`), nil)
+				return client
+			},
+			berglasSecret: &BerglasSecret{
+				Spec: BerglasSecretSpec{
+					Data: map[string]string{
+						"password": "sm://project/db",
+					},
+					DataOptions: map[string]DataOption{
+						"password": {JSONPath: "{.password}", Path: "password"},
+					},
+				},
+			},
+			expectedWarnings: nil,
+			expectedError:    true,
+		},
+		"don't return error when target is valid": {
+			createMockBerglasSecretClient: func(ctrl *gomock.Controller) berglasClient {
+				return mock_v1alpha1.NewMockberglasClient(ctrl)
+			},
+			berglasSecret: &BerglasSecret{
+				Spec: BerglasSecretSpec{
+					Target: SecretTarget{
+						Name:        "app-secret",
+						Labels:      map[string]string{"app.kubernetes.io/name": "app"},
+						Annotations: map[string]string{"example.com/owner": "team"},
+					},
+				},
+			},
+			expectedWarnings: nil,
+		},
+		"return error when target name is invalid": {
+			createMockBerglasSecretClient: func(ctrl *gomock.Controller) berglasClient {
+				return mock_v1alpha1.NewMockberglasClient(ctrl)
+			},
+			berglasSecret: &BerglasSecret{
+				Spec: BerglasSecretSpec{
+					Target: SecretTarget{
+						Name: "App_Secret",
+					},
+				},
+			},
+			expectedWarnings: nil,
+			expectedError:    true,
+		},
+		"return error when immutable target is merged": {
+			createMockBerglasSecretClient: func(ctrl *gomock.Controller) berglasClient {
+				return mock_v1alpha1.NewMockberglasClient(ctrl)
+			},
+			berglasSecret: &BerglasSecret{
+				Spec: BerglasSecretSpec{
+					Target: SecretTarget{
+						Immutable:      true,
+						CreationPolicy: CreationPolicyMerge,
+					},
+				},
+			},
+			expectedWarnings: nil,
+			expectedError:    true,
+		},
+		"return error when name of immutable target is too long": {
+			createMockBerglasSecretClient: func(ctrl *gomock.Controller) berglasClient {
+				return mock_v1alpha1.NewMockberglasClient(ctrl)
+			},
+			berglasSecret: &BerglasSecret{
+				ObjectMeta: metav1.ObjectMeta{Name: strings.Repeat("a", 243)},
+				Spec: BerglasSecretSpec{
+					Target: SecretTarget{Immutable: true},
+				},
+			},
+			expectedWarnings: nil,
+			expectedError:    true,
+		},
+		"don't return error when name of immutable target is short enough": {
+			createMockBerglasSecretClient: func(ctrl *gomock.Controller) berglasClient {
+				return mock_v1alpha1.NewMockberglasClient(ctrl)
+			},
+			berglasSecret: &BerglasSecret{
+				ObjectMeta: metav1.ObjectMeta{Name: strings.Repeat("a", 243)},
+				Spec: BerglasSecretSpec{
+					Target: SecretTarget{Immutable: true, Name: "app"},
+				},
+			},
+			expectedWarnings: nil,
+		},
+		"return error when target annotation is reserved": {
+			createMockBerglasSecretClient: func(ctrl *gomock.Controller) berglasClient {
+				return mock_v1alpha1.NewMockberglasClient(ctrl)
+			},
+			berglasSecret: &BerglasSecret{
+				Spec: BerglasSecretSpec{
+					Target: SecretTarget{
+						Annotations: map[string]string{"kitagry.github.io/berglasSecret": "{}"},
+					},
+				},
+			},
+			expectedWarnings: nil,
+			expectedError:    true,
+		},
 	}
 
 	for n, tt := range tests {
@@ -97,3 +541,98 @@ func TestBerglasSecret_validate(t *testing.T) {
 		})
 	}
 }
+
+func TestBerglasSecretSpec_validateDataFrom(t *testing.T) {
+	tests := map[string]struct {
+		resolveErr    error
+		expectedTypes []field.ErrorType
+	}{
+		"return NotFound when the reference is not found": {
+			resolveErr:    provider.ErrNotFound,
+			expectedTypes: []field.ErrorType{field.ErrorTypeNotFound},
+		},
+		"return InternalError when the reference cannot be resolved for other reasons": {
+			resolveErr:    errors.New("permission denied"),
+			expectedTypes: []field.ErrorType{field.ErrorTypeInternal},
+		},
+	}
+
+	for n, tt := range tests {
+		t.Run(n, func(t *testing.T) {
+			client := mock_v1alpha1.NewMockberglasClient(gomock.NewController(t))
+			client.EXPECT().Validate("sm://project/db").Return(nil)
+			client.EXPECT().Resolve(gomock.Any(), "sm://project/db").Return(nil, tt.resolveErr)
+			spec := &BerglasSecretSpec{
+				DataFrom: []DataFrom{
+					{Ref: "sm://project/db"},
+				},
+			}
+
+			_, errs := spec.validateDataFrom(context.Background(), client)
+			var got []field.ErrorType
+			for _, err := range errs {
+				got = append(got, err.Type)
+			}
+			if diff := cmp.Diff(tt.expectedTypes, got); diff != "" {
+				t.Errorf("validateDataFrom error types diff (-expect, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestBerglasSecretValidator_ValidateUpdate(t *testing.T) {
+	oldBerglasSecret := &BerglasSecret{
+		Spec: BerglasSecretSpec{
+			Data: map[string]string{
+				"some": "berglas://storage/secret",
+			},
+		},
+	}
+
+	tests := map[string]struct {
+		createMockBerglasSecretClient func(ctrl *gomock.Controller) berglasClient
+		berglasSecret                 *BerglasSecret
+		expectedError                 bool
+	}{
+		"don't resolve secrets when only suspend is changed": {
+			createMockBerglasSecretClient: func(ctrl *gomock.Controller) berglasClient {
+				return mock_v1alpha1.NewMockberglasClient(ctrl)
+			},
+			berglasSecret: &BerglasSecret{
+				Spec: BerglasSecretSpec{
+					Data: map[string]string{
+						"some": "berglas://storage/secret",
+					},
+					Suspend: true,
+				},
+			},
+		},
+		"resolve secrets when data is changed": {
+			createMockBerglasSecretClient: func(ctrl *gomock.Controller) berglasClient {
+				client := mock_v1alpha1.NewMockberglasClient(ctrl)
+				client.EXPECT().Validate("berglas://storage/other").Return(nil)
+				client.EXPECT().Resolve(gomock.Any(), "berglas://storage/other").Return(nil, errNotFound)
+				return client
+			},
+			berglasSecret: &BerglasSecret{
+				Spec: BerglasSecretSpec{
+					Data: map[string]string{
+						"some": "berglas://storage/other",
+					},
+					Suspend: true,
+				},
+			},
+			expectedError: true,
+		},
+	}
+
+	for n, tt := range tests {
+		t.Run(n, func(t *testing.T) {
+			validator := &berglasSecretValidator{berglasClient: tt.createMockBerglasSecretClient(gomock.NewController(t))}
+			_, err := validator.ValidateUpdate(context.Background(), oldBerglasSecret, tt.berglasSecret)
+			if (err != nil) != tt.expectedError {
+				t.Errorf("expected error %v, but got %v", tt.expectedError, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ClusterBerglasSecretSpec defines the desired state of ClusterBerglasSecret
+type ClusterBerglasSecretSpec struct {
+	// The Secret built from this spec is written into every selected namespace.
+	// References to k8s-secret and k8s-configmap are allowed only when the source allows all namespaces.
+	BerglasSecretSpec `json:",inline"`
+
+	// NamespaceSelector selects the namespaces by labels.
+	// +optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+
+	// Namespaces is the list of namespaces. Namespaces matching either NamespaceSelector or Namespaces are selected.
+	// +optional
+	Namespaces []string `json:"namespaces,omitempty"`
+}
+
+// ClusterBerglasSecretStatus defines the observed state of ClusterBerglasSecret
+type ClusterBerglasSecretStatus struct {
+	BerglasSecretStatus `json:",inline"`
+
+	// Namespaces is the list of namespaces where Secret is provisioned.
+	// +optional
+	Namespaces []string `json:"namespaces,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Ready",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].status"
+// +kubebuilder:printcolumn:name="Reason",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].reason"
+// +kubebuilder:printcolumn:name="Last Sync",type="date",JSONPath=".status.lastSyncTime"
+// +kubebuilder:printcolumn:name="Suspended",type="boolean",JSONPath=".spec.suspend",priority=1
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// ClusterBerglasSecret is the Schema for the clusterberglassecrets API
+type ClusterBerglasSecret struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ClusterBerglasSecretSpec   `json:"spec,omitempty"`
+	Status ClusterBerglasSecretStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// ClusterBerglasSecretList contains a list of ClusterBerglasSecret
+type ClusterBerglasSecretList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ClusterBerglasSecret `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ClusterBerglasSecret{}, &ClusterBerglasSecretList{})
+}
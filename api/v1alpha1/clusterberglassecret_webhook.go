@@ -0,0 +1,140 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel/attribute"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1validation "k8s.io/apimachinery/pkg/apis/meta/v1/validation"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"github.com/kitagry/berglas-secret-controller/internal/provider"
+	"github.com/kitagry/berglas-secret-controller/internal/tracing"
+)
+
+// log is for logging in this package.
+var clusterberglassecretlog = logf.Log.WithName("clusterberglassecret-resource")
+
+// SetupWebhookWithManager will setup the manager to manage the webhooks.
+// berglasClient is used to check that the references in ClusterBerglasSecret can be resolved.
+func (r *ClusterBerglasSecret) SetupWebhookWithManager(mgr ctrl.Manager, berglasClient berglasClient) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		WithValidator(&clusterBerglasSecretValidator{berglasClient: berglasClient}).
+		Complete()
+}
+
+// +kubebuilder:webhook:path=/validate-batch-kitagry-github-io-v1alpha1-clusterberglassecret,mutating=false,failurePolicy=fail,sideEffects=None,groups=batch.kitagry.github.io,resources=clusterberglassecrets,verbs=create;update,versions=v1alpha1,name=vclusterberglassecret.kb.io,admissionReviewVersions=v1
+
+type clusterBerglasSecretValidator struct {
+	berglasClient berglasClient
+}
+
+var _ webhook.CustomValidator = &clusterBerglasSecretValidator{}
+
+// ValidateCreate implements webhook.CustomValidator so a webhook will be registered for the type
+func (v *clusterBerglasSecretValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	r, ok := obj.(*ClusterBerglasSecret)
+	if !ok {
+		return nil, fmt.Errorf("expected a ClusterBerglasSecret but got %T", obj)
+	}
+	clusterberglassecretlog.V(1).Info("validate create", "name", r.Name)
+
+	return r.validate(ctx, v.berglasClient)
+}
+
+// ValidateUpdate implements webhook.CustomValidator so a webhook will be registered for the type
+func (v *clusterBerglasSecretValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	r, ok := newObj.(*ClusterBerglasSecret)
+	if !ok {
+		return nil, fmt.Errorf("expected a ClusterBerglasSecret but got %T", newObj)
+	}
+	oldClusterBerglasSecret, ok := oldObj.(*ClusterBerglasSecret)
+	if !ok {
+		return nil, nil
+	}
+
+	// RefreshInterval and Suspend don't change the contents of Secret, so we don't need to resolve secrets again.
+	// Suspend must be changeable even when the references are broken.
+	newSpec, oldSpec := r.Spec.DeepCopy(), oldClusterBerglasSecret.Spec.DeepCopy()
+	newSpec.RefreshInterval, oldSpec.RefreshInterval = nil, nil
+	newSpec.Suspend, oldSpec.Suspend = false, false
+	if equality.Semantic.DeepEqual(newSpec, oldSpec) {
+		return nil, nil
+	}
+	clusterberglassecretlog.V(1).Info("validate update", "name", r.Name)
+
+	warnings, err := r.validate(ctx, v.berglasClient)
+	if r.Spec.Type != oldClusterBerglasSecret.Spec.Type {
+		warnings = append(warnings, "spec.type is changed, Secrets will be deleted and created again because the type of Secret is immutable")
+	}
+	return warnings, err
+}
+
+// ValidateDelete implements webhook.CustomValidator so a webhook will be registered for the type
+func (v *clusterBerglasSecretValidator) ValidateDelete(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+func (r *ClusterBerglasSecret) validate(ctx context.Context, berglasClient berglasClient) (_ admission.Warnings, err error) {
+	ctx, span := tracing.Start(ctx, "ClusterBerglasSecret.validate", attribute.String("name", r.Name))
+	defer func() { tracing.End(span, err) }()
+
+	// ClusterBerglasSecret doesn't belong to any namespace, so the references are requested without namespace.
+	warnings, allErrs := r.Spec.BerglasSecretSpec.validate(provider.WithNamespace(ctx, ""), r.Name, berglasClient)
+	allErrs = append(allErrs, r.validateNamespaces()...)
+	if r.Spec.Target.Immutable {
+		allErrs = append(allErrs, field.Forbidden(field.NewPath("spec", "target", "immutable"), "immutable is not supported by ClusterBerglasSecret"))
+	}
+	if len(allErrs) == 0 {
+		return warnings, nil
+	}
+
+	groupVersionKind := r.GroupVersionKind()
+	return warnings, apierrors.NewInvalid(
+		schema.GroupKind{Group: groupVersionKind.Group, Kind: groupVersionKind.Kind},
+		r.Name,
+		allErrs,
+	)
+}
+
+func (r *ClusterBerglasSecret) validateNamespaces() field.ErrorList {
+	var allErrs field.ErrorList
+	if r.Spec.NamespaceSelector != nil {
+		allErrs = append(allErrs, metav1validation.ValidateLabelSelector(r.Spec.NamespaceSelector, metav1validation.LabelSelectorValidationOptions{}, field.NewPath("spec", "namespaceSelector"))...)
+	}
+	for i, namespace := range r.Spec.Namespaces {
+		for _, msg := range validation.IsDNS1123Label(namespace) {
+			allErrs = append(allErrs, field.Invalid(field.NewPath("spec", "namespaces").Index(i), namespace, msg))
+		}
+	}
+	if r.Spec.NamespaceSelector == nil && len(r.Spec.Namespaces) == 0 {
+		allErrs = append(allErrs, field.Required(field.NewPath("spec", "namespaceSelector"), "either namespaceSelector or namespaces is required"))
+	}
+	return allErrs
+}
@@ -0,0 +1,120 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	mock_v1alpha1 "github.com/kitagry/berglas-secret-controller/api/v1alpha1/mock"
+	"github.com/kitagry/berglas-secret-controller/internal/provider"
+	"go.uber.org/mock/gomock"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func TestClusterBerglasSecret_validate(t *testing.T) {
+	tests := map[string]struct {
+		createMockBerglasSecretClient func(ctrl *gomock.Controller) berglasClient
+		clusterBerglasSecret          *ClusterBerglasSecret
+		expectedWarnings              admission.Warnings
+		expectedError                 bool
+	}{
+		"don't return error when namespaces are selected": {
+			createMockBerglasSecretClient: func(ctrl *gomock.Controller) berglasClient {
+				client := mock_v1alpha1.NewMockberglasClient(ctrl)
+				client.EXPECT().Validate("sm://project/key").Return(nil)
+				client.EXPECT().Resolve(gomock.Any(), "sm://project/key").Return([]byte("secret"), nil)
+				return client
+			},
+			clusterBerglasSecret: &ClusterBerglasSecret{
+				Spec: ClusterBerglasSecretSpec{
+					BerglasSecretSpec: BerglasSecretSpec{
+						Data: map[string]string{
+							"api-key": "sm://project/key",
+						},
+					},
+					NamespaceSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"team": "backend"},
+					},
+					Namespaces: []string{"default"},
+				},
+			},
+			expectedWarnings: nil,
+		},
+		"return error when no namespace is selected": {
+			createMockBerglasSecretClient: func(ctrl *gomock.Controller) berglasClient {
+				client := mock_v1alpha1.NewMockberglasClient(ctrl)
+				client.EXPECT().Validate("value").Return(provider.ErrUnsupportedReference)
+				return client
+			},
+			clusterBerglasSecret: &ClusterBerglasSecret{
+				Spec: ClusterBerglasSecretSpec{
+					BerglasSecretSpec: BerglasSecretSpec{
+						Data: map[string]string{
+							"api-key": "value",
+						},
+					},
+				},
+			},
+			expectedWarnings: nil,
+			expectedError:    true,
+		},
+		"return error when namespace name is invalid": {
+			createMockBerglasSecretClient: func(ctrl *gomock.Controller) berglasClient {
+				return mock_v1alpha1.NewMockberglasClient(ctrl)
+			},
+			clusterBerglasSecret: &ClusterBerglasSecret{
+				Spec: ClusterBerglasSecretSpec{
+					Namespaces: []string{"Default"},
+				},
+			},
+			expectedWarnings: nil,
+			expectedError:    true,
+		},
+		"return error when target is immutable": {
+			createMockBerglasSecretClient: func(ctrl *gomock.Controller) berglasClient {
+				return mock_v1alpha1.NewMockberglasClient(ctrl)
+			},
+			clusterBerglasSecret: &ClusterBerglasSecret{
+				Spec: ClusterBerglasSecretSpec{
+					BerglasSecretSpec: BerglasSecretSpec{
+						Target: SecretTarget{Immutable: true},
+					},
+					Namespaces: []string{"default"},
+				},
+			},
+			expectedWarnings: nil,
+			expectedError:    true,
+		},
+	}
+
+	for n, tt := range tests {
+		t.Run(n, func(t *testing.T) {
+			berglasClient := tt.createMockBerglasSecretClient(gomock.NewController(t))
+			got, err := tt.clusterBerglasSecret.validate(context.Background(), berglasClient)
+			if (err != nil) != tt.expectedError {
+				t.Errorf("expected error %v, but got %v", tt.expectedError, err)
+			}
+
+			if diff := cmp.Diff(tt.expectedWarnings, got); diff != "" {
+				t.Errorf("validate result diff (-expect, +got)\n%s", diff)
+			}
+		})
+	}
+}
@@ -53,3 +53,17 @@ func (mr *MockberglasClientMockRecorder) Resolve(ctx, ref any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Resolve", reflect.TypeOf((*MockberglasClient)(nil).Resolve), ctx, ref)
 }
+
+// Validate mocks base method.
+func (m *MockberglasClient) Validate(ref string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Validate", ref)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Validate indicates an expected call of Validate.
+func (mr *MockberglasClientMockRecorder) Validate(ref any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Validate", reflect.TypeOf((*MockberglasClient)(nil).Validate), ref)
+}
@@ -40,6 +40,8 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
+
+	"github.com/kitagry/berglas-secret-controller/internal/provider"
 )
 
 // These tests use Ginkgo (BDD-style Go testing framework). Refer to
@@ -113,7 +115,10 @@ var _ = BeforeSuite(func() {
 	})
 	Expect(err).NotTo(HaveOccurred())
 
-	err = (&BerglasSecret{}).SetupWebhookWithManager(mgr)
+	err = (&BerglasSecret{}).SetupWebhookWithManager(mgr, provider.NewRegistry())
+	Expect(err).NotTo(HaveOccurred())
+
+	err = (&ClusterBerglasSecret{}).SetupWebhookWithManager(mgr, provider.NewRegistry())
 	Expect(err).NotTo(HaveOccurred())
 
 	// +kubebuilder:scaffold:webhook
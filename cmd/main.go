@@ -18,15 +18,21 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
+	"strings"
+	"time"
 
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
@@ -38,6 +44,10 @@ import (
 	batchv1alpha1 "github.com/kitagry/berglas-secret-controller/api/v1alpha1"
 	"github.com/kitagry/berglas-secret-controller/internal/berglas"
 	berglascontroller "github.com/kitagry/berglas-secret-controller/internal/controller"
+	"github.com/kitagry/berglas-secret-controller/internal/kubernetes"
+	"github.com/kitagry/berglas-secret-controller/internal/provider"
+	"github.com/kitagry/berglas-secret-controller/internal/tracing"
+	"github.com/kitagry/berglas-secret-controller/internal/vault"
 	// +kubebuilder:scaffold:imports
 )
 
@@ -67,15 +77,37 @@ func init() {
 
 func main() {
 	var metricsAddr string
+	var probeAddr string
+	var checkCredentials bool
 	var enableLeaderElection bool
 	var certDir string
 	var certServiceName string
+	var vaultAddr string
+	var vaultNamespace string
+	var vaultAuthMethod string
+	var vaultAuthMount string
+	var vaultRole string
+	var vaultAppRoleSecret string
+	var reloadWorkloads bool
+	var traceOpts tracing.Options
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
+	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
+	flag.BoolVar(&checkCredentials, "readyz-check-credentials", false, "Report not ready when an access token of the Google default credentials cannot be obtained")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
 	flag.StringVar(&certDir, "cert-dir", "/certs", "The directory where certs are stored, defaults to /certs")
 	flag.StringVar(&certServiceName, "cert-service-name", "berglas-secret-webhook-service", "The service name used to generate the TLS cert's hostname. Defaults to berglas-secret-webhook-service")
+	flag.StringVar(&vaultAddr, "vault-address", os.Getenv("VAULT_ADDR"), "The address of Vault server. vault:// references are disabled when it is empty. Defaults to VAULT_ADDR")
+	flag.StringVar(&vaultNamespace, "vault-namespace", os.Getenv("VAULT_NAMESPACE"), "The Vault Enterprise namespace. Defaults to VAULT_NAMESPACE")
+	flag.StringVar(&vaultAuthMethod, "vault-auth-method", "kubernetes", "The auth method to log in to Vault, one of kubernetes or approle")
+	flag.StringVar(&vaultAuthMount, "vault-auth-mount", "", "The path where the Vault auth method is mounted. Defaults to the name of the auth method")
+	flag.StringVar(&vaultRole, "vault-role", "", "The Vault role used by the kubernetes auth method")
+	flag.StringVar(&vaultAppRoleSecret, "vault-approle-secret", "", "The Secret which has role_id and secret_id for the approle auth method, in the form of <namespace>/<name> or <name> in POD_NAMESPACE")
+	flag.BoolVar(&reloadWorkloads, "reload-workloads", false, "Roll out Deployments, StatefulSets and DaemonSets annotated with berglas.kitagry.github.io/reload=true when the Secrets they reference are changed")
+	flag.StringVar(&traceOpts.Exporter, "trace-exporter", tracing.DefaultExporter(), "The exporter of OpenTelemetry spans, one of none or otlp. Defaults to OTEL_TRACES_EXPORTER or none")
+	flag.StringVar(&traceOpts.Endpoint, "otlp-endpoint", "", "The host:port of the OTLP gRPC receiver. Defaults to OTEL_EXPORTER_OTLP_TRACES_ENDPOINT or OTEL_EXPORTER_OTLP_ENDPOINT")
+	flag.BoolVar(&traceOpts.Insecure, "otlp-insecure", false, "Disable TLS of the connection to the OTLP receiver")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -90,6 +122,12 @@ func main() {
 	}
 	ctrl.SetLogger(zapr.NewLogger(logger))
 
+	shutdownTracing, err := tracing.Setup(context.Background(), traceOpts)
+	if err != nil {
+		setupLog.Error(err, "unable to set up tracing")
+		os.Exit(1)
+	}
+
 	certSetupFinished := make(chan struct{})
 	webhookServer := &waitCertWebhookServer{
 		Server: webhook.NewServer(webhook.Options{
@@ -103,9 +141,10 @@ func main() {
 		Metrics: metricsserver.Options{
 			BindAddress: metricsAddr,
 		},
-		LeaderElection:   enableLeaderElection,
-		LeaderElectionID: "bbb146c0.kitagry.github.io",
-		WebhookServer:    webhookServer,
+		HealthProbeBindAddress: probeAddr,
+		LeaderElection:         enableLeaderElection,
+		LeaderElectionID:       "bbb146c0.kitagry.github.io",
+		WebhookServer:          webhookServer,
 	})
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
@@ -118,16 +157,56 @@ func main() {
 		setupLog.Error(err, "failed to create berglas client")
 		os.Exit(1)
 	}
+	registry := provider.NewRegistry()
+	berglasClient.Register(registry)
+	kubernetes.Register(registry, mgr.GetClient(), mgr.GetAPIReader())
+
+	if vaultAddr != "" {
+		vaultAuth, err := newVaultAuthenticator(vaultAuthMethod, vaultAuthMount, vaultRole, vaultAppRoleSecret, mgr.GetAPIReader())
+		if err != nil {
+			setupLog.Error(err, "invalid vault auth configuration")
+			os.Exit(1)
+		}
+		vaultClient, err := vault.New(vault.Config{
+			Address:   vaultAddr,
+			Namespace: vaultNamespace,
+			Auth:      vaultAuth,
+		})
+		if err != nil {
+			setupLog.Error(err, "failed to create vault client")
+			os.Exit(1)
+		}
+		vaultClient.Register(registry)
+	} else {
+		vault.RegisterNotConfigured(registry)
+	}
 
 	if err = (&berglascontroller.BerglasSecretReconciler{
 		Client:  mgr.GetClient(),
 		Log:     ctrl.Log.WithName("controller").WithName("BerglasSecret"),
 		Scheme:  mgr.GetScheme(),
-		Berglas: berglasClient,
+		Berglas: registry,
+
+		Recorder:        mgr.GetEventRecorderFor("berglassecret-controller"),
+		ReloadWorkloads: reloadWorkloads,
 	}).SetupWithManager(ctx, mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "BerglasSecret")
 		os.Exit(1)
 	}
+	if err = (&berglascontroller.ClusterBerglasSecretReconciler{
+		BerglasSecretReconciler: berglascontroller.BerglasSecretReconciler{
+			Client:  mgr.GetClient(),
+			Log:     ctrl.Log.WithName("controller").WithName("ClusterBerglasSecret"),
+			Scheme:  mgr.GetScheme(),
+			Berglas: registry,
+
+			Recorder:        mgr.GetEventRecorderFor("clusterberglassecret-controller"),
+			ReloadWorkloads: reloadWorkloads,
+		},
+	}).SetupWithManager(ctx, mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ClusterBerglasSecret")
+		os.Exit(1)
+	}
 	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
 		setupLog.Info("setting up cert rotation")
 		webhooks := []rotator.WebhookInfo{
@@ -159,20 +238,62 @@ func main() {
 		}
 
 		// setup webhook manager
-		if err = (&batchv1alpha1.BerglasSecret{}).SetupWebhookWithManager(mgr); err != nil {
+		if err = (&batchv1alpha1.BerglasSecret{}).SetupWebhookWithManager(mgr, registry); err != nil {
 			setupLog.Error(err, "unable to create webhook", "webhook", "BerglasSecret")
 			os.Exit(1)
 		}
+		if err = (&batchv1alpha1.ClusterBerglasSecret{}).SetupWebhookWithManager(mgr, registry); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "ClusterBerglasSecret")
+			os.Exit(1)
+		}
+		if err := mgr.AddReadyzCheck("webhook", webhookServer.StartedChecker()); err != nil {
+			setupLog.Error(err, "unable to set up ready check", "check", "webhook")
+			os.Exit(1)
+		}
 	} else {
 		close(certSetupFinished)
 	}
 	// +kubebuilder:scaffold:builder
 
+	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
+		setupLog.Error(err, "unable to set up health check")
+		os.Exit(1)
+	}
+	if err := mgr.AddReadyzCheck("cert", certReadyChecker(certSetupFinished)); err != nil {
+		setupLog.Error(err, "unable to set up ready check", "check", "cert")
+		os.Exit(1)
+	}
+	if checkCredentials {
+		if err := mgr.AddReadyzCheck("credentials", func(_ *http.Request) error { return berglasClient.CheckCredentials() }); err != nil {
+			setupLog.Error(err, "unable to set up ready check", "check", "credentials")
+			os.Exit(1)
+		}
+	}
+
 	setupLog.Info("starting manager")
 	if err := mgr.Start(ctx); err != nil {
 		setupLog.Error(err, "problem running manager")
 		os.Exit(1)
 	}
+
+	// ctx is already canceled, so the remaining spans are flushed with a new context.
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		setupLog.Error(err, "failed to flush spans")
+	}
+}
+
+// certReadyChecker reports not ready until the certs of the webhook server are set up.
+func certReadyChecker(certSetupFinished <-chan struct{}) healthz.Checker {
+	return func(_ *http.Request) error {
+		select {
+		case <-certSetupFinished:
+			return nil
+		default:
+			return errors.New("cert setup is not finished")
+		}
+	}
 }
 
 type waitCertWebhookServer struct {
@@ -189,3 +310,27 @@ func (w *waitCertWebhookServer) Start(ctx context.Context) error {
 	}
 	return nil
 }
+
+func newVaultAuthenticator(method, mount, role, appRoleSecret string, reader client.Reader) (vault.Authenticator, error) {
+	switch method {
+	case "kubernetes":
+		if role == "" {
+			return nil, fmt.Errorf("--vault-role is required for the kubernetes auth method")
+		}
+		return &vault.KubernetesAuth{Mount: mount, Role: role}, nil
+	case "approle":
+		if appRoleSecret == "" {
+			return nil, fmt.Errorf("--vault-approle-secret is required for the approle auth method")
+		}
+		namespace, name, ok := strings.Cut(appRoleSecret, "/")
+		if !ok {
+			namespace, name = os.Getenv("POD_NAMESPACE"), appRoleSecret
+		}
+		return &vault.AppRoleAuth{
+			Mount:  mount,
+			Reader: reader,
+			Secret: types.NamespacedName{Namespace: namespace, Name: name},
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown vault auth method %q", method)
+}
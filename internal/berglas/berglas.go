@@ -2,19 +2,38 @@ package berglas
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"strings"
 
 	secretmanager "cloud.google.com/go/secretmanager/apiv1"
-	"cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
 	"cloud.google.com/go/storage"
 	"github.com/GoogleCloudPlatform/berglas/pkg/berglas"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/kitagry/berglas-secret-controller/internal/provider"
+)
+
+const (
+	// SchemeStorage is the scheme of berglas references stored in Cloud Storage.
+	SchemeStorage = "berglas"
+	// SchemeSecretManager is the scheme of berglas references stored in Secret Manager.
+	SchemeSecretManager = "sm"
+
+	// providerStorage and providerSecretManager are the provider labels of the metrics.
+	providerStorage       = "storage"
+	providerSecretManager = "secretmanager"
+
+	cloudPlatformScope = "https://www.googleapis.com/auth/cloud-platform"
 )
 
 type Client struct {
-	bClient    *berglas.Client
-	srManager  *secretmanager.Client
-	gcrManager *storage.Client
+	bClient     *berglas.Client
+	srManager   *secretmanager.Client
+	gcrManager  *storage.Client
+	tokenSource oauth2.TokenSource
 }
 
 func New(ctx context.Context) (*Client, error) {
@@ -33,46 +52,38 @@ func New(ctx context.Context) (*Client, error) {
 		return nil, fmt.Errorf("failed to create storage client: %w", err)
 	}
 
+	creds, err := google.FindDefaultCredentials(ctx, cloudPlatformScope)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find default credentials: %w", err)
+	}
+
 	return &Client{
-		bClient:    client,
-		srManager:  srManager,
-		gcrManager: gcrManager,
+		bClient:     client,
+		srManager:   srManager,
+		gcrManager:  gcrManager,
+		tokenSource: oauth2.ReuseTokenSource(nil, creds.TokenSource),
 	}, nil
 }
 
-func (b *Client) Resolve(ctx context.Context, s string) ([]byte, error) {
-	return b.bClient.Resolve(ctx, s)
-}
-
-func (b *Client) Version(ctx context.Context, s string) (string, error) {
-	ref, err := berglas.ParseReference(s)
-	if err != nil {
-		return "", fmt.Errorf("failed to parse reference %s: %w", s, err)
+// CheckCredentials returns an error when an access token of the default credentials cannot be obtained.
+// The token is cached until it expires, so it is cheap enough to be called by the readiness probe.
+func (b *Client) CheckCredentials() error {
+	if _, err := b.tokenSource.Token(); err != nil {
+		return fmt.Errorf("failed to get access token: %w", err)
 	}
+	return nil
+}
 
-	switch ref.Type() {
-	case berglas.ReferenceTypeSecretManager:
-		version := ref.Version()
-		if version == "" {
-			version = "latest"
-		}
-
-		v, err := b.srManager.GetSecretVersion(ctx, &secretmanagerpb.GetSecretVersionRequest{
-			Name: fmt.Sprintf("projects/%s/secrets/%s/versions/%s", ref.Project(), ref.Name(), version),
-		})
-		if err != nil {
-			return "", fmt.Errorf("failed to get secret version: %w", err)
-		}
-
-		return fmt.Sprintf("%d-%s", v.CreateTime.Seconds, strings.Trim(v.Etag, "\"")), nil
-	case berglas.ReferenceTypeStorage:
-		obj := b.gcrManager.Bucket(ref.Bucket()).Object(ref.Object())
-		attrs, err := obj.Attrs(ctx)
-		if err != nil {
-			return "", fmt.Errorf("failed to get object attributes: %w", err)
-		}
+// Register registers the Cloud Storage and Secret Manager providers to r.
+func (b *Client) Register(r *provider.Registry) {
+	r.Register(SchemeStorage, &StorageProvider{client: b})
+	r.Register(SchemeSecretManager, &SecretManagerProvider{client: b})
+}
 
-		return fmt.Sprintf("%d", attrs.CRC32C), nil
+// notFound makes err match provider.ErrNotFound when the secret doesn't exist.
+func notFound(err error) error {
+	if berglas.IsSecretDoesNotExistErr(err) || errors.Is(err, storage.ErrObjectNotExist) || status.Code(err) == codes.NotFound {
+		return provider.NotFound(err)
 	}
-	return "", fmt.Errorf("unknown reference type %v", ref.Type())
+	return err
 }
@@ -0,0 +1,91 @@
+package berglas
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
+	"github.com/GoogleCloudPlatform/berglas/pkg/berglas"
+
+	"github.com/kitagry/berglas-secret-controller/internal/metrics"
+	"github.com/kitagry/berglas-secret-controller/internal/provider"
+	"github.com/kitagry/berglas-secret-controller/internal/tracing"
+)
+
+// SecretManagerProvider resolves sm://<project>/<name>[#<version>] references.
+type SecretManagerProvider struct {
+	client *Client
+}
+
+var (
+	_ provider.Provider           = &SecretManagerProvider{}
+	_ provider.VersionCreateTimer = &SecretManagerProvider{}
+)
+
+func (s *SecretManagerProvider) Resolve(ctx context.Context, ref string) (_ []byte, err error) {
+	ctx, span := tracing.Start(ctx, "SecretManagerProvider.Resolve", tracing.ReferenceType(ref))
+	defer func() { tracing.End(span, err) }()
+
+	plaintext, err := s.client.bClient.Resolve(ctx, ref)
+	if err != nil {
+		err = notFound(err)
+	}
+	var project string
+	if r, parseErr := parseReference(ref, berglas.ReferenceTypeSecretManager); parseErr == nil {
+		project = r.Project()
+	}
+	metrics.ObserveProviderCall(providerSecretManager, "resolve", project, err)
+	if err != nil {
+		return nil, err
+	}
+	return plaintext, nil
+}
+
+func (s *SecretManagerProvider) Version(ctx context.Context, ref string) (_ string, err error) {
+	ctx, span := tracing.Start(ctx, "SecretManagerProvider.Version", tracing.ReferenceType(ref))
+	defer func() { tracing.End(span, err) }()
+
+	r, err := parseReference(ref, berglas.ReferenceTypeSecretManager)
+	if err != nil {
+		return "", err
+	}
+
+	version := r.Version()
+	if version == "" {
+		version = "latest"
+	}
+
+	v, err := s.client.srManager.GetSecretVersion(ctx, &secretmanagerpb.GetSecretVersionRequest{
+		Name: fmt.Sprintf("projects/%s/secrets/%s/versions/%s", r.Project(), r.Name(), version),
+	})
+	if err != nil {
+		err = notFound(err)
+	}
+	metrics.ObserveProviderCall(providerSecretManager, "version", r.Project(), err)
+	if err != nil {
+		return "", fmt.Errorf("failed to get secret version: %w", err)
+	}
+
+	return fmt.Sprintf("%d-%s", v.CreateTime.Seconds, strings.Trim(v.Etag, "\"")), nil
+}
+
+// VersionCreateTime parses the creation time of the secret version from the version returned by Version.
+func (s *SecretManagerProvider) VersionCreateTime(ref, version string) (time.Time, bool) {
+	seconds, _, ok := strings.Cut(version, "-")
+	if !ok {
+		return time.Time{}, false
+	}
+	n, err := strconv.ParseInt(seconds, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(n, 0), true
+}
+
+func (s *SecretManagerProvider) Validate(ref string) error {
+	_, err := parseReference(ref, berglas.ReferenceTypeSecretManager)
+	return err
+}
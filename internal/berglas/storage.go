@@ -0,0 +1,77 @@
+package berglas
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/GoogleCloudPlatform/berglas/pkg/berglas"
+
+	"github.com/kitagry/berglas-secret-controller/internal/metrics"
+	"github.com/kitagry/berglas-secret-controller/internal/provider"
+	"github.com/kitagry/berglas-secret-controller/internal/tracing"
+)
+
+// StorageProvider resolves berglas://<bucket>/<object> references.
+type StorageProvider struct {
+	client *Client
+}
+
+var _ provider.Provider = &StorageProvider{}
+
+func (s *StorageProvider) Resolve(ctx context.Context, ref string) (_ []byte, err error) {
+	ctx, span := tracing.Start(ctx, "StorageProvider.Resolve", tracing.ReferenceType(ref))
+	defer func() { tracing.End(span, err) }()
+
+	plaintext, err := s.client.bClient.Resolve(ctx, ref)
+	if err != nil {
+		err = notFound(err)
+	}
+	// Cloud Storage references don't have the project, so the bucket is recorded instead.
+	var bucket string
+	if r, parseErr := parseReference(ref, berglas.ReferenceTypeStorage); parseErr == nil {
+		bucket = r.Bucket()
+	}
+	metrics.ObserveProviderCall(providerStorage, "resolve", bucket, err)
+	if err != nil {
+		return nil, err
+	}
+	return plaintext, nil
+}
+
+func (s *StorageProvider) Version(ctx context.Context, ref string) (_ string, err error) {
+	ctx, span := tracing.Start(ctx, "StorageProvider.Version", tracing.ReferenceType(ref))
+	defer func() { tracing.End(span, err) }()
+
+	r, err := parseReference(ref, berglas.ReferenceTypeStorage)
+	if err != nil {
+		return "", err
+	}
+
+	obj := s.client.gcrManager.Bucket(r.Bucket()).Object(r.Object())
+	attrs, err := obj.Attrs(ctx)
+	if err != nil {
+		err = notFound(err)
+	}
+	metrics.ObserveProviderCall(providerStorage, "version", r.Bucket(), err)
+	if err != nil {
+		return "", fmt.Errorf("failed to get object attributes: %w", err)
+	}
+
+	return fmt.Sprintf("%d", attrs.CRC32C), nil
+}
+
+func (s *StorageProvider) Validate(ref string) error {
+	_, err := parseReference(ref, berglas.ReferenceTypeStorage)
+	return err
+}
+
+func parseReference(s string, typ berglas.ReferenceType) (*berglas.Reference, error) {
+	ref, err := berglas.ParseReference(s)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse reference %s: %w", s, err)
+	}
+	if ref.Type() != typ {
+		return nil, fmt.Errorf("unknown reference type %v", ref.Type())
+	}
+	return ref, nil
+}
@@ -21,24 +21,40 @@ import (
 	"time"
 
 	"github.com/go-logr/logr"
+	"go.opentelemetry.io/otel/attribute"
 	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	batchv1alpha1 "github.com/kitagry/berglas-secret-controller/api/v1alpha1"
+	"github.com/kitagry/berglas-secret-controller/internal/kubernetes"
+	"github.com/kitagry/berglas-secret-controller/internal/metrics"
+	"github.com/kitagry/berglas-secret-controller/internal/provider"
+	"github.com/kitagry/berglas-secret-controller/internal/tracing"
 )
 
 const (
 	ownerControllerField = ".metadata.controller"
+	sourceRefField       = ".spec.sourceRefs"
 
 	defaultRefreshInterval = 10 * time.Minute
 )
 
+// berglasClient resolves secret references. It is usually *provider.Registry.
+// Validate returns an error when the value is not a reference.
 type berglasClient interface {
 	Resolve(context.Context, string) ([]byte, error)
 	Version(context.Context, string) (string, error)
+	Validate(string) error
+	VersionCreateTime(ref, version string) (time.Time, bool)
 }
 
 // BerglasSecretReconciler reconciles a BerglasSecret object
@@ -47,52 +63,129 @@ type BerglasSecretReconciler struct {
 	Log     logr.Logger
 	Scheme  *runtime.Scheme
 	Berglas berglasClient
+	// Recorder records the events of the syncs on the resource and Secret.
+	Recorder record.EventRecorder
+	// ReloadWorkloads rolls out the workloads with the reload annotation when the Secrets they reference are changed.
+	ReloadWorkloads bool
 }
 
 // +kubebuilder:rbac:groups=batch.kitagry.github.io,resources=berglassecrets,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=batch.kitagry.github.io,resources=berglassecrets/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=batch.kitagry.github.io,resources=berglassecrets/finalizers,verbs=update
 // +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups="",resources=secrets/status,verbs=get
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+
+func (r *BerglasSecretReconciler) Reconcile(ctx context.Context, req ctrl.Request) (_ ctrl.Result, err error) {
+	ctx, span := tracing.Start(ctx, "BerglasSecret.Reconcile", attribute.String("namespace", req.Namespace), attribute.String("name", req.Name))
+	defer func() { tracing.End(span, err) }()
 
-func (r *BerglasSecretReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	logger := r.Log.WithValues("berglassecret", req.NamespacedName)
+	ctx = provider.WithNamespace(ctx, req.Namespace)
 
-	// your logic here
 	var berglasSecret batchv1alpha1.BerglasSecret
 	if err := r.Get(ctx, req.NamespacedName, &berglasSecret); err != nil {
+		if k8serrors.IsNotFound(err) {
+			metrics.Forget(metrics.ObjectKey{Kind: "BerglasSecret", Namespace: req.Namespace, Name: req.Name})
+		}
 		logger.Error(err, "failed to fetch berglas_secret")
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
-	if err := r.reconcileSecret(ctx, req, &berglasSecret); err != nil {
+	return r.reconcileObject(ctx, logger, reconciledObject{
+		kind:         "BerglasSecret",
+		obj:          &berglasSecret,
+		spec:         &berglasSecret.Spec,
+		status:       &berglasSecret.Status,
+		ownedSecrets: []client.ListOption{client.InNamespace(berglasSecret.Namespace), client.MatchingFields{ownerControllerField: berglasSecret.Name}},
+		sync: func(ctx context.Context) (*v1.Secret, error) {
+			return r.reconcileSecret(ctx, &berglasSecret)
+		},
+	})
+}
+
+// reconciledObject is BerglasSecret or ClusterBerglasSecret, which reconcileObject reconciles.
+type reconciledObject struct {
+	kind   string
+	obj    client.Object
+	spec   *batchv1alpha1.BerglasSecretSpec
+	status *batchv1alpha1.BerglasSecretStatus
+	// ownedSecrets selects the Secrets which may be controlled by obj.
+	ownedSecrets []client.ListOption
+	// sync writes the Secrets of obj, and returns one of the synced Secrets.
+	sync func(context.Context) (*v1.Secret, error)
+}
+
+// reconcileObject handles the finalizer and suspension of o, syncs its Secrets, and updates its status.
+func (r *BerglasSecretReconciler) reconcileObject(ctx context.Context, logger logr.Logger, o reconciledObject) (ctrl.Result, error) {
+	if !o.obj.GetDeletionTimestamp().IsZero() {
+		err := r.finalize(ctx, o.obj, &o.spec.Target, o.ownedSecrets...)
+		if err != nil {
+			logger.Error(err, "failed to finalize")
+		}
+		return ctrl.Result{}, err
+	}
+	if err := r.syncFinalizer(ctx, o.obj, &o.spec.Target); err != nil {
+		logger.Error(err, "failed to update finalizer")
+		return ctrl.Result{}, err
+	}
+
+	generation := o.obj.GetGeneration()
+	setSuspendedCondition(o.status, generation, o.spec.Suspend)
+	if o.spec.Suspend {
+		// Secret is left as is, and the reconciliation is resumed when spec.suspend is unset.
+		o.status.ObservedGeneration = generation
+		o.status.NextSyncTime = nil
+		if err := r.Status().Update(ctx, o.obj); err != nil {
+			logger.Error(err, "failed to update status")
+			return ctrl.Result{}, err
+		}
+		logger.Info("skip reconciliation because it is suspended")
+		return ctrl.Result{}, nil
+	}
+
+	token := forceSyncToken(o.obj, o.status)
+	start := time.Now()
+	secret, err := o.sync(ctx)
+	observeSync(o.kind, o.obj, start, secret, err)
+	o.status.ObservedGeneration = generation
+	if secret != nil {
+		o.status.SecretName = secret.Name
+	}
+	now := metav1.Now()
+	keys := keyStatuses(o.spec, secret, managerFor(o.obj, o.spec.Target.CreationPolicy), err, o.status.Keys, now, r.Berglas.VersionCreateTime)
+	observePropagationLag(o.obj.GetNamespace(), o.status.Keys, keys)
+	o.status.Keys = keys
+	setReadyCondition(o.status, generation, err)
+	if err != nil {
 		logger.Error(err, "failed to reconcile secret")
-		setCondition(&berglasSecret.Status, batchv1alpha1.BerglasSecretCondition{
-			Type:    batchv1alpha1.BerglasSecretFailure,
-			Status:  metav1.ConditionFalse,
-			Reason:  err.Error(),
-			Message: "Failed to reconcile secret resource",
-		})
-		stErr := r.Status().Update(ctx, &berglasSecret)
+		// ClusterBerglasSecret has a Secret per namespace, so the failure is recorded only to itself.
+		if o.obj.GetNamespace() == "" {
+			secret = nil
+		}
+		r.recordFailed(o.obj, secret, err)
+		o.status.NextSyncTime = nil
+		stErr := r.Status().Update(ctx, o.obj)
 		if stErr != nil {
-			logger.Error(err, "failed to update status")
+			logger.Error(stErr, "failed to update status")
 		}
 		return ctrl.Result{}, err
 	}
 
-	setCondition(&berglasSecret.Status, batchv1alpha1.BerglasSecretCondition{
-		Type:   batchv1alpha1.BerglasSecretAvailable,
-		Status: metav1.ConditionTrue,
-	})
-	err := r.Status().Update(ctx, &berglasSecret)
-	if err != nil {
+	refreshInterval := getOrDefault(o.spec.RefreshInterval, metav1.Duration{Duration: defaultRefreshInterval}).Duration
+	if token != "" {
+		o.status.LastForceSyncToken = token
+	}
+	o.status.LastSyncTime = &now
+	o.status.NextSyncTime = &metav1.Time{Time: now.Add(refreshInterval)}
+	if err := r.Status().Update(ctx, o.obj); err != nil {
 		logger.Error(err, "failed to update status")
 		return ctrl.Result{}, err
 	}
 
 	logger.Info("success to reconcile")
-	return ctrl.Result{
-		RequeueAfter: getOrDefault(berglasSecret.Spec.RefreshInterval, metav1.Duration{Duration: defaultRefreshInterval}).Duration,
-	}, nil
+	return ctrl.Result{RequeueAfter: refreshInterval}, nil
 }
 
 func (r *BerglasSecretReconciler) SetupWithManager(ctx context.Context, mgr ctrl.Manager) error {
@@ -112,12 +205,79 @@ func (r *BerglasSecretReconciler) SetupWithManager(ctx context.Context, mgr ctrl
 		return err
 	}
 
+	if err := mgr.GetFieldIndexer().IndexField(ctx, &batchv1alpha1.BerglasSecret{}, sourceRefField, func(rawObj client.Object) []string {
+		bs := rawObj.(*batchv1alpha1.BerglasSecret)
+		return sourceKeys(&bs.Spec)
+	}); err != nil {
+		return err
+	}
+
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&batchv1alpha1.BerglasSecret{}).
+		// Status updates don't change the generation, so they don't trigger another reconciliation.
+		For(&batchv1alpha1.BerglasSecret{}, builder.WithPredicates(predicate.Or(predicate.GenerationChangedPredicate{}, predicate.AnnotationChangedPredicate{}))).
 		Owns(&v1.Secret{}).
+		Watches(&v1.Secret{}, handler.EnqueueRequestsFromMapFunc(r.findBerglasSecretsForSource(kubernetes.SchemeSecret))).
+		Watches(&v1.ConfigMap{}, handler.EnqueueRequestsFromMapFunc(r.findBerglasSecretsForSource(kubernetes.SchemeConfigMap)), builder.OnlyMetadata).
 		Complete(r)
 }
 
+// sourceKeys returns the keys of Secrets and ConfigMaps which spec references.
+func sourceKeys(spec *batchv1alpha1.BerglasSecretSpec) []string {
+	var keys []string
+	for _, value := range versionedValues(spec) {
+		ref, err := kubernetes.ParseReference(value)
+		if err != nil {
+			continue
+		}
+		keys = append(keys, ref.SourceKey())
+	}
+	return keys
+}
+
+// findBerglasSecretsForSource returns the map function which enqueues BerglasSecrets referencing the object,
+// so that the changes of the source are propagated immediately.
+func (r *BerglasSecretReconciler) findBerglasSecretsForSource(scheme string) handler.MapFunc {
+	return func(ctx context.Context, obj client.Object) []reconcile.Request {
+		var list batchv1alpha1.BerglasSecretList
+		sourceKey := kubernetes.SourceKey(scheme, client.ObjectKeyFromObject(obj))
+		if err := r.List(ctx, &list, client.MatchingFields{sourceRefField: sourceKey}); err != nil {
+			r.Log.Error(err, "failed to list berglas secrets referencing the source", "source", sourceKey)
+			return nil
+		}
+
+		requests := make([]reconcile.Request, 0, len(list.Items))
+		for _, bs := range list.Items {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&bs)})
+		}
+		return requests
+	}
+}
+
+// observeSync records the metrics of the sync of obj which started at start.
+// secret is the synced Secret, and it is nil when Secret isn't synced.
+func observeSync(kind string, obj client.Object, start time.Time, secret *v1.Secret, err error) {
+	keys := -1
+	if secret != nil {
+		keys = len(secret.Data)
+	}
+	metrics.ObserveSync(metrics.ObjectKey{Kind: kind, Namespace: obj.GetNamespace(), Name: obj.GetName()}, start, keys, err)
+}
+
+// observePropagationLag records the propagation lag of the keys whose versions are changed from previous.
+// keyStatuses sets the lag only when the same reference is rotated, so the keys whose references are changed are not recorded.
+func observePropagationLag(namespace string, previous, current []batchv1alpha1.KeyStatus) {
+	last := make(map[string]string, len(previous))
+	for _, status := range previous {
+		last[status.Key] = status.Version
+	}
+	for _, status := range current {
+		if status.PropagationLag == nil || last[status.Key] == "" || last[status.Key] == status.Version {
+			continue
+		}
+		metrics.ObservePropagationLag(namespace, status.PropagationLag.Duration)
+	}
+}
+
 func getOrDefault[T any](t *T, defaultValue T) T {
 	if t == nil {
 		return defaultValue
@@ -0,0 +1,254 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"go.opentelemetry.io/otel/attribute"
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	batchv1alpha1 "github.com/kitagry/berglas-secret-controller/api/v1alpha1"
+	"github.com/kitagry/berglas-secret-controller/internal/kubernetes"
+	"github.com/kitagry/berglas-secret-controller/internal/metrics"
+	"github.com/kitagry/berglas-secret-controller/internal/provider"
+	"github.com/kitagry/berglas-secret-controller/internal/tracing"
+)
+
+const clusterOwnerControllerField = ".metadata.clusterController"
+
+// ClusterBerglasSecretReconciler reconciles a ClusterBerglasSecret object.
+// It resolves the values in the same way as BerglasSecretReconciler, and writes the Secret into the selected namespaces.
+type ClusterBerglasSecretReconciler struct {
+	BerglasSecretReconciler
+}
+
+// +kubebuilder:rbac:groups=batch.kitagry.github.io,resources=clusterberglassecrets,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=batch.kitagry.github.io,resources=clusterberglassecrets/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=batch.kitagry.github.io,resources=clusterberglassecrets/finalizers,verbs=update
+// +kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+
+func (r *ClusterBerglasSecretReconciler) Reconcile(ctx context.Context, req ctrl.Request) (_ ctrl.Result, err error) {
+	ctx, span := tracing.Start(ctx, "ClusterBerglasSecret.Reconcile", attribute.String("name", req.Name))
+	defer func() { tracing.End(span, err) }()
+
+	logger := r.Log.WithValues("clusterberglassecret", req.Name)
+	// ClusterBerglasSecret doesn't belong to any namespace,
+	// so it can reference only the sources which allow all namespaces.
+	ctx = provider.WithNamespace(ctx, "")
+
+	var clusterBerglasSecret batchv1alpha1.ClusterBerglasSecret
+	if err := r.Get(ctx, req.NamespacedName, &clusterBerglasSecret); err != nil {
+		if k8serrors.IsNotFound(err) {
+			metrics.Forget(metrics.ObjectKey{Kind: "ClusterBerglasSecret", Name: req.Name})
+		}
+		logger.Error(err, "failed to fetch cluster_berglas_secret")
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	return r.reconcileObject(ctx, logger, reconciledObject{
+		kind:         "ClusterBerglasSecret",
+		obj:          &clusterBerglasSecret,
+		spec:         &clusterBerglasSecret.Spec.BerglasSecretSpec,
+		status:       &clusterBerglasSecret.Status.BerglasSecretStatus,
+		ownedSecrets: []client.ListOption{client.MatchingFields{clusterOwnerControllerField: clusterBerglasSecret.Name}},
+		sync: func(ctx context.Context) (*v1.Secret, error) {
+			namespaces, secret, err := r.reconcileSecrets(ctx, &clusterBerglasSecret)
+			clusterBerglasSecret.Status.Namespaces = namespaces
+			return secret, err
+		},
+	})
+}
+
+// reconcileSecrets writes the Secret into the selected namespaces, and deletes the Secrets in the other namespaces.
+// It returns the namespaces where the Secret is provisioned and one of the synced Secrets.
+func (r *ClusterBerglasSecretReconciler) reconcileSecrets(ctx context.Context, cbs *batchv1alpha1.ClusterBerglasSecret) ([]string, *v1.Secret, error) {
+	namespaces, err := r.selectNamespaces(ctx, cbs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	name := clusterSecretName(cbs)
+	w := r.newSecretWriter(cbs, &cbs.Spec.BerglasSecretSpec, &cbs.Status.BerglasSecretStatus)
+	var synced *v1.Secret
+	var errs []error
+	provisioned := make([]string, 0, len(namespaces))
+	for _, namespace := range namespaces {
+		secret, err := w.sync(ctx, types.NamespacedName{Namespace: namespace, Name: name})
+		if w.err != nil {
+			// The values are shared by all namespaces, so none of them can be written.
+			return provisioned, nil, w.err
+		}
+		if secret != nil {
+			synced = secret
+			provisioned = append(provisioned, namespace)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("namespace %s: %w", namespace, err))
+		}
+	}
+	errs = append(errs, w.partialErr())
+
+	if err := r.deleteStaleSecrets(ctx, cbs, sets.New(namespaces...)); err != nil {
+		errs = append(errs, err)
+	}
+	return provisioned, synced, errors.Join(errs...)
+}
+
+// clusterSecretName returns the name of Secret generated from cbs.
+func clusterSecretName(cbs *batchv1alpha1.ClusterBerglasSecret) string {
+	if cbs.Spec.Target.Name != "" {
+		return cbs.Spec.Target.Name
+	}
+	return cbs.Name
+}
+
+// selectNamespaces returns the namespaces matching either the namespaceSelector or the namespaces of cbs.
+// Terminating namespaces are skipped because Secrets cannot be created in them.
+func (r *ClusterBerglasSecretReconciler) selectNamespaces(ctx context.Context, cbs *batchv1alpha1.ClusterBerglasSecret) ([]string, error) {
+	selector := labels.Nothing()
+	if cbs.Spec.NamespaceSelector != nil {
+		var err error
+		selector, err = metav1.LabelSelectorAsSelector(cbs.Spec.NamespaceSelector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid namespaceSelector: %w", err)
+		}
+	}
+	names := sets.New(cbs.Spec.Namespaces...)
+
+	var list v1.NamespaceList
+	if err := r.List(ctx, &list); err != nil {
+		return nil, fmt.Errorf("failed to list namespaces: %w", err)
+	}
+
+	var result []string
+	for _, ns := range list.Items {
+		if ns.Status.Phase == v1.NamespaceTerminating {
+			continue
+		}
+		if names.Has(ns.Name) || selector.Matches(labels.Set(ns.Labels)) {
+			result = append(result, ns.Name)
+		}
+	}
+	slices.Sort(result)
+	return result, nil
+}
+
+// deleteStaleSecrets deletes the Secrets owned by cbs which are not in namespaces or whose name is not the current target name.
+func (r *ClusterBerglasSecretReconciler) deleteStaleSecrets(ctx context.Context, cbs *batchv1alpha1.ClusterBerglasSecret, namespaces sets.Set[string]) error {
+	var secrets v1.SecretList
+	if err := r.List(ctx, &secrets, client.MatchingFields{clusterOwnerControllerField: cbs.Name}); err != nil {
+		return fmt.Errorf("failed to list owned secrets: %w", err)
+	}
+
+	name := clusterSecretName(cbs)
+	for _, secret := range secrets.Items {
+		if namespaces.Has(secret.Namespace) && secret.Name == name {
+			continue
+		}
+		owner := metav1.GetControllerOf(&secret)
+		if owner == nil || owner.UID != cbs.UID {
+			continue
+		}
+		err := r.Delete(ctx, &secret, client.Preconditions{UID: &secret.UID})
+		if err != nil && !k8serrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete stale secret %s/%s: %w", secret.Namespace, secret.Name, err)
+		}
+	}
+	return nil
+}
+
+func (r *ClusterBerglasSecretReconciler) SetupWithManager(ctx context.Context, mgr ctrl.Manager) error {
+	if err := mgr.GetFieldIndexer().IndexField(ctx, &v1.Secret{}, clusterOwnerControllerField, func(rawObj client.Object) []string {
+		secret := rawObj.(*v1.Secret)
+		owner := metav1.GetControllerOf(secret)
+		if owner == nil {
+			return nil
+		}
+
+		if owner.Kind != "ClusterBerglasSecret" {
+			return nil
+		}
+
+		return []string{owner.Name}
+	}); err != nil {
+		return err
+	}
+
+	if err := mgr.GetFieldIndexer().IndexField(ctx, &batchv1alpha1.ClusterBerglasSecret{}, sourceRefField, func(rawObj client.Object) []string {
+		cbs := rawObj.(*batchv1alpha1.ClusterBerglasSecret)
+		return sourceKeys(&cbs.Spec.BerglasSecretSpec)
+	}); err != nil {
+		return err
+	}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&batchv1alpha1.ClusterBerglasSecret{}, builder.WithPredicates(predicate.Or(predicate.GenerationChangedPredicate{}, predicate.AnnotationChangedPredicate{}))).
+		Owns(&v1.Secret{}).
+		// Namespaces are reconciled when they are created or their labels are changed.
+		Watches(&v1.Namespace{}, handler.EnqueueRequestsFromMapFunc(r.findClusterBerglasSecrets), builder.WithPredicates(predicate.LabelChangedPredicate{})).
+		Watches(&v1.Secret{}, handler.EnqueueRequestsFromMapFunc(r.findClusterBerglasSecretsForSource(kubernetes.SchemeSecret))).
+		Watches(&v1.ConfigMap{}, handler.EnqueueRequestsFromMapFunc(r.findClusterBerglasSecretsForSource(kubernetes.SchemeConfigMap)), builder.OnlyMetadata).
+		Complete(r)
+}
+
+// findClusterBerglasSecrets enqueues all ClusterBerglasSecrets, because any of them may start or stop selecting the namespace.
+func (r *ClusterBerglasSecretReconciler) findClusterBerglasSecrets(ctx context.Context, obj client.Object) []reconcile.Request {
+	var list batchv1alpha1.ClusterBerglasSecretList
+	if err := r.List(ctx, &list); err != nil {
+		r.Log.Error(err, "failed to list cluster berglas secrets", "namespace", obj.GetName())
+		return nil
+	}
+
+	requests := make([]reconcile.Request, 0, len(list.Items))
+	for _, cbs := range list.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&cbs)})
+	}
+	return requests
+}
+
+// findClusterBerglasSecretsForSource returns the map function which enqueues ClusterBerglasSecrets referencing the object.
+func (r *ClusterBerglasSecretReconciler) findClusterBerglasSecretsForSource(scheme string) handler.MapFunc {
+	return func(ctx context.Context, obj client.Object) []reconcile.Request {
+		var list batchv1alpha1.ClusterBerglasSecretList
+		sourceKey := kubernetes.SourceKey(scheme, client.ObjectKeyFromObject(obj))
+		if err := r.List(ctx, &list, client.MatchingFields{sourceRefField: sourceKey}); err != nil {
+			r.Log.Error(err, "failed to list cluster berglas secrets referencing the source", "source", sourceKey)
+			return nil
+		}
+
+		requests := make([]reconcile.Request, 0, len(list.Items))
+		for _, cbs := range list.Items {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&cbs)})
+		}
+		return requests
+	}
+}
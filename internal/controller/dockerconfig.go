@@ -0,0 +1,58 @@
+package controller
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+
+	batchv1alpha1 "github.com/kitagry/berglas-secret-controller/api/v1alpha1"
+)
+
+// dockerConfigVersionPrefix is the prefix of DockerConfig fields in the version annotation.
+// "/" is not allowed in the keys of Secret, so they don't conflict with the keys of Data.
+const dockerConfigVersionPrefix = "dockerConfig/"
+
+// dockerConfigJSON is the format of .dockerconfigjson key of kubernetes.io/dockerconfigjson Secret.
+type dockerConfigJSON struct {
+	Auths map[string]dockerConfigEntry `json:"auths"`
+}
+
+type dockerConfigEntry struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Email    string `json:"email,omitempty"`
+	Auth     string `json:"auth"`
+}
+
+// dockerConfigValues returns the fields of dc keyed by the name in the version annotation.
+func dockerConfigValues(dc *batchv1alpha1.DockerConfig) map[string]string {
+	values := map[string]string{
+		dockerConfigVersionPrefix + "registry": dc.Registry,
+		dockerConfigVersionPrefix + "username": dc.Username,
+		dockerConfigVersionPrefix + "password": dc.Password,
+	}
+	if dc.Email != "" {
+		values[dockerConfigVersionPrefix+"email"] = dc.Email
+	}
+	return values
+}
+
+// buildDockerConfigJSON resolves the fields of dc and returns the content of .dockerconfigjson.
+func (r *BerglasSecretReconciler) buildDockerConfigJSON(ctx context.Context, dc *batchv1alpha1.DockerConfig) ([]byte, error) {
+	values, err := r.resolveBerglasSchemas(ctx, dockerConfigValues(dc), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	username, password := string(values[dockerConfigVersionPrefix+"username"]), string(values[dockerConfigVersionPrefix+"password"])
+	return json.Marshal(dockerConfigJSON{
+		Auths: map[string]dockerConfigEntry{
+			string(values[dockerConfigVersionPrefix+"registry"]): {
+				Username: username,
+				Password: password,
+				Email:    string(values[dockerConfigVersionPrefix+"email"]),
+				Auth:     base64.StdEncoding.EncodeToString([]byte(username + ":" + password)),
+			},
+		},
+	})
+}
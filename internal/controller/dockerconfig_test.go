@@ -0,0 +1,38 @@
+package controller
+
+import (
+	"context"
+	"log"
+	"testing"
+
+	"github.com/go-logr/stdr"
+	"github.com/google/go-cmp/cmp"
+	batchv1alpha1 "github.com/kitagry/berglas-secret-controller/api/v1alpha1"
+	mockcontroller "github.com/kitagry/berglas-secret-controller/internal/controller/mock"
+	"github.com/kitagry/berglas-secret-controller/internal/provider"
+	"go.uber.org/mock/gomock"
+)
+
+func TestBerglasSecretReconciler_buildDockerConfigJSON(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	berglasClient := mockcontroller.NewMockberglasClient(ctrl)
+	berglasClient.EXPECT().Validate("asia-docker.pkg.dev").Return(provider.ErrUnsupportedReference)
+	berglasClient.EXPECT().Validate("_json_key").Return(provider.ErrUnsupportedReference)
+	berglasClient.EXPECT().Validate("sm://project/key").Return(nil)
+	berglasClient.EXPECT().Resolve(gomock.Any(), "sm://project/key").Return([]byte("password"), nil)
+	reconciler := &BerglasSecretReconciler{Berglas: berglasClient, Log: stdr.New(log.Default())}
+
+	got, err := reconciler.buildDockerConfigJSON(context.Background(), &batchv1alpha1.DockerConfig{
+		Registry: "asia-docker.pkg.dev",
+		Username: "_json_key",
+		Password: "sm://project/key",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := `{"auths":{"asia-docker.pkg.dev":{"username":"_json_key","password":"password","auth":"X2pzb25fa2V5OnBhc3N3b3Jk"}}}`
+	if diff := cmp.Diff(expected, string(got)); diff != "" {
+		t.Errorf("buildDockerConfigJSON result diff (-expect, +got)\n%s", diff)
+	}
+}
@@ -0,0 +1,96 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Reasons of the Failure condition. They are also used to classify keyError.
+const (
+	reasonResolveFailed   = "ResolveFailed"
+	reasonExtractFailed   = "ExtractFailed"
+	reasonParseFailed     = "ParseFailed"
+	reasonKeyConflict     = "KeyConflict"
+	reasonReconcileFailed = "ReconcileFailed"
+	reasonAdoptionRefused = "AdoptionRefused"
+	reasonSecretNotFound  = "SecretNotFound"
+)
+
+// keyError is an error which occurred while building a value of BerglasSecret.
+// Key is the same as the key of the version annotation, such as a key of spec.data or dataFrom/<index>.
+type keyError struct {
+	Key    string
+	Reason string
+	Err    error
+}
+
+func (e *keyError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Key, e.Err)
+}
+
+func (e *keyError) Unwrap() error {
+	return e.Err
+}
+
+// secretError is an error which prevents the controller from writing the target Secret.
+type secretError struct {
+	Reason string
+	Err    error
+}
+
+func (e *secretError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *secretError) Unwrap() error {
+	return e.Err
+}
+
+// keyErrors returns all keyErrors in the tree of err.
+func keyErrors(err error) []*keyError {
+	switch e := err.(type) {
+	case *keyError:
+		return []*keyError{e}
+	case interface{ Unwrap() []error }:
+		var result []*keyError
+		for _, err := range e.Unwrap() {
+			result = append(result, keyErrors(err)...)
+		}
+		return result
+	case interface{ Unwrap() error }:
+		return keyErrors(e.Unwrap())
+	default:
+		return nil
+	}
+}
+
+// isKeyErrors reports whether err consists only of keyErrors.
+func isKeyErrors(err error) bool {
+	switch e := err.(type) {
+	case *keyError:
+		return true
+	case interface{ Unwrap() []error }:
+		for _, err := range e.Unwrap() {
+			if !isKeyErrors(err) {
+				return false
+			}
+		}
+		return true
+	case interface{ Unwrap() error }:
+		return isKeyErrors(e.Unwrap())
+	default:
+		return false
+	}
+}
+
+// failureReason returns the reason of the Failure condition for err.
+func failureReason(err error) string {
+	if errs := keyErrors(err); len(errs) > 0 {
+		return errs[0].Reason
+	}
+	var se *secretError
+	if errors.As(err, &se) {
+		return se.Reason
+	}
+	return reasonReconcileFailed
+}
@@ -0,0 +1,99 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	batchv1alpha1 "github.com/kitagry/berglas-secret-controller/api/v1alpha1"
+)
+
+// Reasons of the Normal events. The Warning events have the reasons of the Ready condition.
+const (
+	eventReasonCreated   = "Created"
+	eventReasonUpdated   = "Updated"
+	eventReasonUnchanged = "Unchanged"
+)
+
+// recordCreated records that secret is created for owner.
+func (r *BerglasSecretReconciler) recordCreated(owner runtime.Object, secret *v1.Secret) {
+	r.recordNormal(owner, secret, eventReasonCreated, fmt.Sprintf("Secret %s/%s is created", secret.Namespace, secret.Name))
+}
+
+// recordUpdated records that current is updated to desired for owner.
+// The event has only the names of the changed keys, never the values.
+// With the Merge policy, the keys of the other managers are not compared.
+func (r *BerglasSecretReconciler) recordUpdated(owner client.Object, current, desired *v1.Secret, policy batchv1alpha1.CreationPolicy) {
+	message := fmt.Sprintf("Secret %s/%s is updated", desired.Namespace, desired.Name)
+	currentData := current.Data
+	if policy == batchv1alpha1.CreationPolicyMerge {
+		currentData = managedData(current, desired, managerFor(owner, policy))
+	}
+	if keys := changedKeys(currentData, desired.Data); len(keys) > 0 {
+		message += ", changed keys: " + strings.Join(keys, ", ")
+	}
+	r.recordNormal(owner, desired, eventReasonUpdated, message)
+}
+
+// recordUnchanged records that secret is up to date for owner.
+func (r *BerglasSecretReconciler) recordUnchanged(owner runtime.Object, secret *v1.Secret) {
+	r.recordNormal(owner, secret, eventReasonUnchanged, fmt.Sprintf("Secret %s/%s is up to date", secret.Namespace, secret.Name))
+}
+
+func (r *BerglasSecretReconciler) recordNormal(owner runtime.Object, secret *v1.Secret, reason, message string) {
+	r.Recorder.Event(owner, v1.EventTypeNormal, reason, message)
+	r.Recorder.Event(secret, v1.EventTypeNormal, reason, message)
+}
+
+// recordFailed records err of the reconciliation of owner with the reason of the Ready condition.
+// secret is the synced Secret, and it is nil when Secret isn't synced.
+func (r *BerglasSecretReconciler) recordFailed(owner runtime.Object, secret *v1.Secret, err error) {
+	reason := failureReason(err)
+	r.Recorder.Event(owner, v1.EventTypeWarning, reason, err.Error())
+	if secret != nil {
+		r.Recorder.Event(secret, v1.EventTypeWarning, reason, err.Error())
+	}
+}
+
+// managedData returns the data of current whose keys are written by m,
+// which are the keys of desired and the keys recorded in the annotations of current.
+func managedData(current, desired *v1.Secret, m secretManager) map[string][]byte {
+	keys := sets.New(slices.Collect(maps.Keys(desired.Data))...)
+	var data map[string]string
+	if err := json.Unmarshal([]byte(current.Annotations[m.dataKey]), &data); err == nil {
+		keys.Insert(slices.Collect(maps.Keys(data))...)
+	}
+	for _, dataFromKeys := range m.secretDataFromKeys(current) {
+		keys.Insert(dataFromKeys...)
+	}
+
+	result := make(map[string][]byte, keys.Len())
+	for key := range keys {
+		if value, ok := current.Data[key]; ok {
+			result[key] = value
+		}
+	}
+	return result
+}
+
+// changedKeys returns the sorted keys which are added, removed or changed from current to desired.
+func changedKeys(current, desired map[string][]byte) []string {
+	keys := sets.New(slices.Collect(maps.Keys(current))...).Insert(slices.Collect(maps.Keys(desired))...)
+	var result []string
+	for _, key := range sets.List(keys) {
+		c, cok := current[key]
+		d, dok := desired[key]
+		if cok != dok || !bytes.Equal(c, d) {
+			result = append(result, key)
+		}
+	}
+	return result
+}
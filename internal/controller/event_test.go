@@ -0,0 +1,79 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestChangedKeys(t *testing.T) {
+	tests := map[string]struct {
+		current  map[string][]byte
+		desired  map[string][]byte
+		expected []string
+	}{
+		"no change": {
+			current:  map[string][]byte{"user": []byte("admin")},
+			desired:  map[string][]byte{"user": []byte("admin")},
+			expected: nil,
+		},
+		"added, removed and changed keys": {
+			current:  map[string][]byte{"user": []byte("admin"), "password": []byte("old"), "token": []byte("t")},
+			desired:  map[string][]byte{"user": []byte("admin"), "password": []byte("new"), "api-key": []byte("k")},
+			expected: []string{"api-key", "password", "token"},
+		},
+		"empty value is different from missing key": {
+			current:  map[string][]byte{},
+			desired:  map[string][]byte{"flag": {}},
+			expected: []string{"flag"},
+		},
+	}
+
+	for n, tt := range tests {
+		t.Run(n, func(t *testing.T) {
+			got := changedKeys(tt.current, tt.desired)
+			if diff := cmp.Diff(tt.expected, got); diff != "" {
+				t.Errorf("changedKeys result diff (-expect, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestManagedData(t *testing.T) {
+	current := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{
+				secretAnnotationKey:   `{"password":"sm://project/password","removed":"sm://project/removed"}`,
+				secretDataFromKeysKey: `{"dataFrom/0":["db_user"]}`,
+			},
+		},
+		Data: map[string][]byte{
+			"password": []byte("old"),
+			"removed":  []byte("value"),
+			"db_user":  []byte("admin"),
+			"other":    []byte("owned by another manager"),
+		},
+	}
+	desired := &v1.Secret{
+		Data: map[string][]byte{
+			"password": []byte("new"),
+			"db_user":  []byte("admin"),
+			"api-key":  []byte("k"),
+		},
+	}
+
+	got := managedData(current, desired, ownerManager)
+	expected := map[string][]byte{
+		"password": []byte("old"),
+		"removed":  []byte("value"),
+		"db_user":  []byte("admin"),
+	}
+	if diff := cmp.Diff(expected, got); diff != "" {
+		t.Errorf("managedData result diff (-expect, +got)\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"api-key", "password", "removed"}, changedKeys(got, desired.Data)); diff != "" {
+		t.Errorf("changedKeys result diff (-expect, +got)\n%s", diff)
+	}
+}
@@ -0,0 +1,101 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	batchv1alpha1 "github.com/kitagry/berglas-secret-controller/api/v1alpha1"
+)
+
+// retainFinalizer keeps the resource until its Secrets are retained.
+const retainFinalizer = "kitagry.github.io/retain-secret"
+
+// needsFinalizer reports whether the Secrets written for target must be retained when the resource is deleted.
+// Secrets without the owner reference are not garbage collected, so the finalizer is not needed for them.
+func needsFinalizer(target *batchv1alpha1.SecretTarget) bool {
+	if target.DeletionPolicy != batchv1alpha1.DeletionPolicyRetain {
+		return false
+	}
+	return target.CreationPolicy == "" || target.CreationPolicy == batchv1alpha1.CreationPolicyOwner
+}
+
+// syncFinalizer adds or removes retainFinalizer of obj according to target.
+func (r *BerglasSecretReconciler) syncFinalizer(ctx context.Context, obj client.Object, target *batchv1alpha1.SecretTarget) error {
+	var changed bool
+	if needsFinalizer(target) {
+		changed = controllerutil.AddFinalizer(obj, retainFinalizer)
+	} else {
+		changed = controllerutil.RemoveFinalizer(obj, retainFinalizer)
+	}
+	if !changed {
+		return nil
+	}
+	return r.Update(ctx, obj)
+}
+
+// finalize retains the Secrets controlled by obj, which is being deleted, and removes retainFinalizer.
+// opts select the Secrets which may be controlled by obj.
+func (r *BerglasSecretReconciler) finalize(ctx context.Context, obj client.Object, target *batchv1alpha1.SecretTarget, opts ...client.ListOption) error {
+	if !controllerutil.ContainsFinalizer(obj, retainFinalizer) {
+		return nil
+	}
+
+	if needsFinalizer(target) {
+		var secrets v1.SecretList
+		if err := r.List(ctx, &secrets, opts...); err != nil {
+			return fmt.Errorf("failed to list owned secrets: %w", err)
+		}
+		for i := range secrets.Items {
+			secret := &secrets.Items[i]
+			if !metav1.IsControlledBy(secret, obj) {
+				continue
+			}
+			if err := r.retainSecret(ctx, obj, secret); err != nil {
+				return err
+			}
+		}
+	}
+
+	controllerutil.RemoveFinalizer(obj, retainFinalizer)
+	return r.Update(ctx, obj)
+}
+
+// retainSecret removes the owner reference of owner from secret, so that it is not garbage collected,
+// and labels it as orphaned.
+func (r *BerglasSecretReconciler) retainSecret(ctx context.Context, owner metav1.Object, secret *v1.Secret) error {
+	patch := client.MergeFrom(secret.DeepCopy())
+	secret.OwnerReferences = slices.DeleteFunc(secret.OwnerReferences, func(ref metav1.OwnerReference) bool {
+		return ref.UID == owner.GetUID()
+	})
+	if secret.Labels == nil {
+		secret.Labels = make(map[string]string, 1)
+	}
+	secret.Labels[batchv1alpha1.OrphanedLabel] = "true"
+	if err := r.Patch(ctx, secret, patch); err != nil {
+		return fmt.Errorf("failed to retain secret %s/%s: %w", secret.Namespace, secret.Name, err)
+	}
+	return nil
+}
+
+// isOrphaned reports whether secret was retained after its owner was deleted.
+func isOrphaned(secret *v1.Secret) bool {
+	_, ok := secret.Labels[batchv1alpha1.OrphanedLabel]
+	return ok
+}
+
+// unlabelOrphaned removes the orphaned label from secret which is adopted again.
+// The label was written by a patch, so it is not pruned by server-side apply.
+func (r *BerglasSecretReconciler) unlabelOrphaned(ctx context.Context, secret *v1.Secret) error {
+	patch := client.MergeFrom(secret.DeepCopy())
+	delete(secret.Labels, batchv1alpha1.OrphanedLabel)
+	if err := r.Patch(ctx, secret, patch); err != nil {
+		return fmt.Errorf("failed to remove orphaned label of secret %s/%s: %w", secret.Namespace, secret.Name, err)
+	}
+	return nil
+}
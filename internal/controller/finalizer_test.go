@@ -0,0 +1,44 @@
+package controller
+
+import (
+	"testing"
+
+	batchv1alpha1 "github.com/kitagry/berglas-secret-controller/api/v1alpha1"
+)
+
+func TestNeedsFinalizer(t *testing.T) {
+	tests := map[string]struct {
+		target   batchv1alpha1.SecretTarget
+		expected bool
+	}{
+		"default policies": {
+			target:   batchv1alpha1.SecretTarget{},
+			expected: false,
+		},
+		"Retain": {
+			target:   batchv1alpha1.SecretTarget{DeletionPolicy: batchv1alpha1.DeletionPolicyRetain},
+			expected: true,
+		},
+		"Retain with Owner": {
+			target:   batchv1alpha1.SecretTarget{CreationPolicy: batchv1alpha1.CreationPolicyOwner, DeletionPolicy: batchv1alpha1.DeletionPolicyRetain},
+			expected: true,
+		},
+		"Retain with Orphan": {
+			target:   batchv1alpha1.SecretTarget{CreationPolicy: batchv1alpha1.CreationPolicyOrphan, DeletionPolicy: batchv1alpha1.DeletionPolicyRetain},
+			expected: false,
+		},
+		"Delete": {
+			target:   batchv1alpha1.SecretTarget{DeletionPolicy: batchv1alpha1.DeletionPolicyDelete},
+			expected: false,
+		},
+	}
+
+	for n, tt := range tests {
+		t.Run(n, func(t *testing.T) {
+			got := needsFinalizer(&tt.target)
+			if got != tt.expected {
+				t.Errorf("expected %v, but got %v", tt.expected, got)
+			}
+		})
+	}
+}
@@ -12,6 +12,7 @@ package mock_controller
 import (
 	context "context"
 	reflect "reflect"
+	time "time"
 
 	gomock "go.uber.org/mock/gomock"
 )
@@ -54,6 +55,20 @@ func (mr *MockberglasClientMockRecorder) Resolve(arg0, arg1 any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Resolve", reflect.TypeOf((*MockberglasClient)(nil).Resolve), arg0, arg1)
 }
 
+// Validate mocks base method.
+func (m *MockberglasClient) Validate(arg0 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Validate", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Validate indicates an expected call of Validate.
+func (mr *MockberglasClientMockRecorder) Validate(arg0 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Validate", reflect.TypeOf((*MockberglasClient)(nil).Validate), arg0)
+}
+
 // Version mocks base method.
 func (m *MockberglasClient) Version(arg0 context.Context, arg1 string) (string, error) {
 	m.ctrl.T.Helper()
@@ -68,3 +83,18 @@ func (mr *MockberglasClientMockRecorder) Version(arg0, arg1 any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Version", reflect.TypeOf((*MockberglasClient)(nil).Version), arg0, arg1)
 }
+
+// VersionCreateTime mocks base method.
+func (m *MockberglasClient) VersionCreateTime(ref, version string) (time.Time, bool) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "VersionCreateTime", ref, version)
+	ret0, _ := ret[0].(time.Time)
+	ret1, _ := ret[1].(bool)
+	return ret0, ret1
+}
+
+// VersionCreateTime indicates an expected call of VersionCreateTime.
+func (mr *MockberglasClientMockRecorder) VersionCreateTime(ref, version any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "VersionCreateTime", reflect.TypeOf((*MockberglasClient)(nil).VersionCreateTime), ref, version)
+}
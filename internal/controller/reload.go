@@ -0,0 +1,155 @@
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+
+	batchv1alpha1 "github.com/kitagry/berglas-secret-controller/api/v1alpha1"
+)
+
+// +kubebuilder:rbac:groups=apps,resources=deployments;statefulsets;daemonsets,verbs=get;list;watch;patch
+
+// reloadWorkloads sets the checksum of the data of secret to the pod templates of the workloads which opt in to reload and reference secret.
+// The workloads which already have the checksum are not patched, so it is also called for the unchanged Secret
+// to retry the workloads which failed to be reloaded.
+func (r *BerglasSecretReconciler) reloadWorkloads(ctx context.Context, secret *v1.Secret) error {
+	if !r.ReloadWorkloads {
+		return nil
+	}
+
+	workloads, err := r.listWorkloads(ctx, secret.Namespace)
+	if err != nil {
+		return err
+	}
+
+	key := checksumAnnotation(secret.Name)
+	checksum := dataHash(secret.Data)
+	var errs []error
+	for _, workload := range workloads {
+		if workload.GetAnnotations()[batchv1alpha1.ReloadAnnotation] != "true" {
+			continue
+		}
+		template := podTemplate(workload)
+		if !referencesSecret(&template.Spec, secret.Name) {
+			continue
+		}
+		if template.Annotations[key] == checksum {
+			continue
+		}
+
+		patch := client.MergeFrom(workload.DeepCopyObject().(client.Object))
+		if template.Annotations == nil {
+			template.Annotations = make(map[string]string, 1)
+		}
+		template.Annotations[key] = checksum
+		gvk, err := apiutil.GVKForObject(workload, r.Scheme)
+		if err != nil {
+			return err
+		}
+		if err := r.Patch(ctx, workload, patch); err != nil {
+			errs = append(errs, fmt.Errorf("failed to reload %s %s: %w", gvk.Kind, workload.GetName(), err))
+			continue
+		}
+		r.Log.Info("reloaded workload", "kind", gvk.Kind, "namespace", workload.GetNamespace(), "name", workload.GetName(), "secret", secret.Name)
+	}
+	return errors.Join(errs...)
+}
+
+// listWorkloads returns the Deployments, StatefulSets and DaemonSets in namespace.
+func (r *BerglasSecretReconciler) listWorkloads(ctx context.Context, namespace string) ([]client.Object, error) {
+	var deployments appsv1.DeploymentList
+	var statefulSets appsv1.StatefulSetList
+	var daemonSets appsv1.DaemonSetList
+	for _, list := range []client.ObjectList{&deployments, &statefulSets, &daemonSets} {
+		if err := r.List(ctx, list, client.InNamespace(namespace)); err != nil {
+			return nil, fmt.Errorf("failed to list workloads: %w", err)
+		}
+	}
+
+	workloads := make([]client.Object, 0, len(deployments.Items)+len(statefulSets.Items)+len(daemonSets.Items))
+	for i := range deployments.Items {
+		workloads = append(workloads, &deployments.Items[i])
+	}
+	for i := range statefulSets.Items {
+		workloads = append(workloads, &statefulSets.Items[i])
+	}
+	for i := range daemonSets.Items {
+		workloads = append(workloads, &daemonSets.Items[i])
+	}
+	return workloads, nil
+}
+
+// podTemplate returns the pod template of workload, which is one of the objects returned by listWorkloads.
+func podTemplate(workload client.Object) *v1.PodTemplateSpec {
+	switch w := workload.(type) {
+	case *appsv1.Deployment:
+		return &w.Spec.Template
+	case *appsv1.StatefulSet:
+		return &w.Spec.Template
+	case *appsv1.DaemonSet:
+		return &w.Spec.Template
+	default:
+		panic(fmt.Sprintf("unsupported workload %T", workload))
+	}
+}
+
+// referencesSecret reports whether the Pods of spec read the Secret named name through env, envFrom or volumes.
+func referencesSecret(spec *v1.PodSpec, name string) bool {
+	for _, c := range slices.Concat(spec.InitContainers, spec.Containers) {
+		for _, env := range c.Env {
+			if env.ValueFrom != nil && env.ValueFrom.SecretKeyRef != nil && env.ValueFrom.SecretKeyRef.Name == name {
+				return true
+			}
+		}
+		for _, envFrom := range c.EnvFrom {
+			if envFrom.SecretRef != nil && envFrom.SecretRef.Name == name {
+				return true
+			}
+		}
+	}
+	for _, volume := range spec.Volumes {
+		if volume.Secret != nil && volume.Secret.SecretName == name {
+			return true
+		}
+		if volume.Projected == nil {
+			continue
+		}
+		for _, source := range volume.Projected.Sources {
+			if source.Secret != nil && source.Secret.Name == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checksumAnnotation returns the key of the checksum annotation of the Secret named name.
+// The name part of annotation keys is at most 63 characters, so longer names are truncated and suffixed with their hash.
+func checksumAnnotation(name string) string {
+	if len(name) <= validation.DNS1123LabelMaxLength {
+		return batchv1alpha1.ChecksumAnnotationPrefix + name
+	}
+	sum := sha256.Sum256([]byte(name))
+	return batchv1alpha1.ChecksumAnnotationPrefix + name[:validation.DNS1123LabelMaxLength-revisionHashLength-1] + "-" + hex.EncodeToString(sum[:])[:revisionHashLength]
+}
+
+// dataHash returns the hash of data. Unlike contentHash, it isn't changed by the labels and annotations of Secret.
+func dataHash(data map[string][]byte) string {
+	h := sha256.New()
+	for _, key := range slices.Sorted(maps.Keys(data)) {
+		fmt.Fprintf(h, "%s\x00%d\x00", key, len(data[key]))
+		h.Write(data[key])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
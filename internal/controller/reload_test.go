@@ -0,0 +1,107 @@
+package controller
+
+import (
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+func TestReferencesSecret(t *testing.T) {
+	tests := map[string]struct {
+		spec     *v1.PodSpec
+		expected bool
+	}{
+		"env": {
+			spec: &v1.PodSpec{
+				Containers: []v1.Container{{Env: []v1.EnvVar{{
+					Name:      "PASSWORD",
+					ValueFrom: &v1.EnvVarSource{SecretKeyRef: &v1.SecretKeySelector{LocalObjectReference: v1.LocalObjectReference{Name: "app"}, Key: "password"}},
+				}}}},
+			},
+			expected: true,
+		},
+		"envFrom of init container": {
+			spec: &v1.PodSpec{
+				InitContainers: []v1.Container{{EnvFrom: []v1.EnvFromSource{{
+					SecretRef: &v1.SecretEnvSource{LocalObjectReference: v1.LocalObjectReference{Name: "app"}},
+				}}}},
+			},
+			expected: true,
+		},
+		"volume": {
+			spec: &v1.PodSpec{
+				Volumes: []v1.Volume{{VolumeSource: v1.VolumeSource{Secret: &v1.SecretVolumeSource{SecretName: "app"}}}},
+			},
+			expected: true,
+		},
+		"projected volume": {
+			spec: &v1.PodSpec{
+				Volumes: []v1.Volume{{VolumeSource: v1.VolumeSource{Projected: &v1.ProjectedVolumeSource{Sources: []v1.VolumeProjection{
+					{Secret: &v1.SecretProjection{LocalObjectReference: v1.LocalObjectReference{Name: "app"}}},
+				}}}}},
+			},
+			expected: true,
+		},
+		"other secret": {
+			spec: &v1.PodSpec{
+				Containers: []v1.Container{{EnvFrom: []v1.EnvFromSource{{
+					SecretRef: &v1.SecretEnvSource{LocalObjectReference: v1.LocalObjectReference{Name: "other"}},
+				}}}},
+				Volumes: []v1.Volume{{VolumeSource: v1.VolumeSource{ConfigMap: &v1.ConfigMapVolumeSource{LocalObjectReference: v1.LocalObjectReference{Name: "app"}}}}},
+			},
+			expected: false,
+		},
+	}
+
+	for n, tt := range tests {
+		t.Run(n, func(t *testing.T) {
+			got := referencesSecret(tt.spec, "app")
+			if got != tt.expected {
+				t.Errorf("expected %v, but got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestChecksumAnnotation(t *testing.T) {
+	tests := map[string]struct {
+		name     string
+		expected string
+	}{
+		"short name": {
+			name:     "app",
+			expected: "checksum.berglas.kitagry.github.io/app",
+		},
+		"long name": {
+			name:     strings.Repeat("a", 64),
+			expected: "checksum.berglas.kitagry.github.io/" + strings.Repeat("a", 52) + "-ffe054fe7a",
+		},
+	}
+
+	for n, tt := range tests {
+		t.Run(n, func(t *testing.T) {
+			got := checksumAnnotation(tt.name)
+			if got != tt.expected {
+				t.Errorf("expected %s, but got %s", tt.expected, got)
+			}
+			if errs := validation.IsQualifiedName(got); len(errs) > 0 {
+				t.Errorf("invalid annotation key: %v", errs)
+			}
+		})
+	}
+}
+
+func TestDataHash(t *testing.T) {
+	got := dataHash(map[string][]byte{"a": []byte("bc")})
+	if same := dataHash(map[string][]byte{"a": []byte("bc")}); same != got {
+		t.Errorf("the hash should be stable, but got %s and %s", got, same)
+	}
+	if other := dataHash(map[string][]byte{"ab": []byte("c")}); other == got {
+		t.Errorf("the hash should be changed when the keys are changed, but got %s", other)
+	}
+	if other := dataHash(map[string][]byte{"a": []byte("bd")}); other == got {
+		t.Errorf("the hash should be changed when the values are changed, but got %s", other)
+	}
+}
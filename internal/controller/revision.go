@@ -0,0 +1,155 @@
+package controller
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	batchv1alpha1 "github.com/kitagry/berglas-secret-controller/api/v1alpha1"
+)
+
+const (
+	// revisionHashLength is the length of the hash appended to the names of immutable Secrets.
+	revisionHashLength = 10
+
+	defaultRevisionHistoryLimit = 3
+)
+
+// reconcileRevision writes a new immutable Secret when the contents of the current revision are changed,
+// and deletes the old revisions exceeding the history limit. It returns the current revision.
+func (r *BerglasSecretReconciler) reconcileRevision(ctx context.Context, bs *batchv1alpha1.BerglasSecret) (*v1.Secret, error) {
+	current, err := r.currentRevision(ctx, bs)
+	if err != nil {
+		return nil, err
+	}
+	w := r.newSecretWriter(bs, &bs.Spec, &bs.Status)
+	if current != nil && !w.force {
+		isChanged, err := w.isChanged(ctx, current)
+		if err != nil {
+			return nil, err
+		}
+		if !isChanged {
+			r.recordUnchanged(bs, current)
+			return current, r.deleteOldRevisions(ctx, bs, current.Name)
+		}
+	}
+
+	desired, err := w.build(ctx, types.NamespacedName{Namespace: bs.Namespace, Name: secretName(bs)}, current)
+	if err != nil {
+		return nil, err
+	}
+	immutable := true
+	desired.Name = revisionName(desired.Name, desired)
+	desired.Immutable = &immutable
+	written, writeErr := w.writeRevision(ctx, desired)
+	if writeErr != nil {
+		return nil, writeErr
+	}
+	if written {
+		r.recordCreated(bs, desired)
+	} else {
+		r.recordUnchanged(bs, desired)
+	}
+	if deleteErr := r.deleteOldRevisions(ctx, bs, desired.Name); deleteErr != nil {
+		return nil, deleteErr
+	}
+	return desired, w.partialErr()
+}
+
+// currentRevision returns the revision named status.secretName. It returns nil when it doesn't exist.
+func (r *BerglasSecretReconciler) currentRevision(ctx context.Context, bs *batchv1alpha1.BerglasSecret) (*v1.Secret, error) {
+	if !strings.HasPrefix(bs.Status.SecretName, secretName(bs)+"-") {
+		return nil, nil
+	}
+
+	var secret v1.Secret
+	err := r.Get(ctx, types.NamespacedName{Namespace: bs.Namespace, Name: bs.Status.SecretName}, &secret)
+	if k8serrors.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if !metav1.IsControlledBy(&secret, bs) || !ownerManager.isManaged(&secret) {
+		return nil, nil
+	}
+	return &secret, nil
+}
+
+// writeRevision writes desired unless the same revision exists, e.g. when the versions are rolled back.
+// It reports whether desired is written.
+func (w *secretWriter) writeRevision(ctx context.Context, desired *v1.Secret) (bool, error) {
+	var existing v1.Secret
+	err := w.Get(ctx, client.ObjectKeyFromObject(desired), &existing)
+	if k8serrors.IsNotFound(err) {
+		return true, w.applySecret(ctx, desired)
+	} else if err != nil {
+		return false, err
+	}
+
+	if err := checkAdoption(w.owner, &w.spec.Target, &existing); err != nil {
+		return false, err
+	}
+	if metav1.IsControlledBy(&existing, w.owner) && getOrDefault(existing.Immutable, false) && maps.EqualFunc(existing.Data, desired.Data, bytes.Equal) {
+		// The existing revision is returned as is, so that its metadata such as the UID is kept.
+		existing.DeepCopyInto(desired)
+		return false, nil
+	}
+	return true, w.replaceSecret(ctx, &existing, desired)
+}
+
+// deleteOldRevisions deletes the Secrets controlled by bs other than current,
+// except for the newest ones within the revision history limit.
+func (r *BerglasSecretReconciler) deleteOldRevisions(ctx context.Context, bs *batchv1alpha1.BerglasSecret, current string) error {
+	var secrets v1.SecretList
+	err := r.List(ctx, &secrets, client.InNamespace(bs.Namespace), client.MatchingFields{ownerControllerField: bs.Name})
+	if err != nil {
+		return fmt.Errorf("failed to list owned secrets: %w", err)
+	}
+
+	old := slices.DeleteFunc(secrets.Items, func(secret v1.Secret) bool {
+		return secret.Name == current || !metav1.IsControlledBy(&secret, bs)
+	})
+	for _, secret := range expiredRevisions(old, bs.Spec.Target.RevisionHistoryLimit) {
+		err := r.Delete(ctx, &secret, client.Preconditions{UID: &secret.UID})
+		if err != nil && !k8serrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete old revision %s: %w", secret.Name, err)
+		}
+	}
+	return nil
+}
+
+// expiredRevisions returns the revisions which are older than the newest ones within limit.
+func expiredRevisions(revisions []v1.Secret, limit *int32) []v1.Secret {
+	slices.SortFunc(revisions, func(a, b v1.Secret) int {
+		return cmp.Or(b.CreationTimestamp.Compare(a.CreationTimestamp.Time), strings.Compare(b.Name, a.Name))
+	})
+	return revisions[min(int(getOrDefault(limit, defaultRevisionHistoryLimit)), len(revisions)):]
+}
+
+// revisionName returns <name>-<hash>, where the hash is the contentHash of secret.
+func revisionName(name string, secret *v1.Secret) string {
+	return name + "-" + contentHash(secret)[:revisionHashLength]
+}
+
+// contentHash returns the hash of the annotations of secret which identify the spec and the versions of the references,
+// so that it is changed when the contents of secret are changed.
+func contentHash(secret *v1.Secret) string {
+	h := sha256.New()
+	for _, key := range []string{secretAnnotationKey, secretVersionKey, secretSpecHashKey} {
+		fmt.Fprintf(h, "%s\x00", secret.Annotations[key])
+	}
+	h.Write([]byte(secret.Type))
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,153 @@
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	batchv1alpha1 "github.com/kitagry/berglas-secret-controller/api/v1alpha1"
+)
+
+func TestRevisionName(t *testing.T) {
+	newSecret := func(versions string) *v1.Secret {
+		return &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Annotations: map[string]string{
+					secretAnnotationKey: `{"password":"sm://project/password"}`,
+					secretVersionKey:    versions,
+				},
+			},
+		}
+	}
+
+	got := revisionName("app", newSecret(`{"password":"1"}`))
+	if !strings.HasPrefix(got, "app-") || len(got) != len("app-")+revisionHashLength {
+		t.Errorf("expected app-<hash>, but got %s", got)
+	}
+	if same := revisionName("app", newSecret(`{"password":"1"}`)); same != got {
+		t.Errorf("expected the same name %s, but got %s", got, same)
+	}
+	if changed := revisionName("app", newSecret(`{"password":"2"}`)); changed == got {
+		t.Errorf("expected a different name from %s when the versions are changed", got)
+	}
+}
+
+func TestSecretWriter_writeRevision(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = batchv1alpha1.AddToScheme(scheme)
+
+	bs := &batchv1alpha1.BerglasSecret{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", UID: types.UID("bs-uid")},
+		Spec: batchv1alpha1.BerglasSecretSpec{
+			Target: batchv1alpha1.SecretTarget{Immutable: true},
+		},
+	}
+	immutable := true
+	newRevision := func() *v1.Secret {
+		secret := &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "app-0123456789",
+				Namespace:   "default",
+				Annotations: map[string]string{secretAnnotationKey: `{"password":"sm://project/password"}`},
+			},
+			Immutable: &immutable,
+			Data:      map[string][]byte{"password": []byte("p@ss")},
+		}
+		if err := setOwnerReference(bs, secret, batchv1alpha1.CreationPolicyOwner, scheme); err != nil {
+			t.Fatal(err)
+		}
+		return secret
+	}
+	existing := newRevision()
+	existing.UID = types.UID("revision-uid")
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(existing).Build()
+	if err := c.Get(context.Background(), client.ObjectKeyFromObject(existing), existing); err != nil {
+		t.Fatal(err)
+	}
+	r := &BerglasSecretReconciler{Client: c, Scheme: scheme}
+
+	// The versions are rolled back to the ones of the existing revision.
+	desired := newRevision()
+	written, err := r.newSecretWriter(bs, &bs.Spec, &bs.Status).writeRevision(context.Background(), desired)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if written {
+		t.Error("the existing revision should be reused")
+	}
+	if diff := cmp.Diff(existing, desired); diff != "" {
+		t.Errorf("the existing revision should be returned (-expect, +got)\n%s", diff)
+	}
+}
+
+func TestBerglasSecretReconciler_deleteOldSecrets(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = batchv1alpha1.AddToScheme(scheme)
+
+	limit := int32(1)
+	bs := &batchv1alpha1.BerglasSecret{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", UID: types.UID("bs-uid")},
+		Spec: batchv1alpha1.BerglasSecretSpec{
+			Target: batchv1alpha1.SecretTarget{RevisionHistoryLimit: &limit},
+		},
+	}
+	immutable := true
+	newSecret := func(name string, created int64, isRevision bool) client.Object {
+		secret := &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              name,
+				Namespace:         "default",
+				UID:               types.UID(name + "-uid"),
+				CreationTimestamp: metav1.Unix(created, 0),
+			},
+		}
+		if isRevision {
+			secret.Immutable = &immutable
+		}
+		if err := setOwnerReference(bs, secret, batchv1alpha1.CreationPolicyOwner, scheme); err != nil {
+			t.Fatal(err)
+		}
+		return secret
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).
+		WithObjects(
+			newSecret("app", 4, false),
+			newSecret("app-0000000001", 1, true),
+			newSecret("app-0000000002", 2, true),
+			newSecret("old-name", 3, false),
+		).
+		WithIndex(&v1.Secret{}, ownerControllerField, func(obj client.Object) []string {
+			return []string{metav1.GetControllerOf(obj).Name}
+		}).
+		Build()
+	r := &BerglasSecretReconciler{Client: c, Scheme: scheme}
+
+	if err := r.deleteOldSecrets(context.Background(), bs); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var secrets v1.SecretList
+	if err := c.List(context.Background(), &secrets); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, secret := range secrets.Items {
+		got = append(got, secret.Name)
+	}
+	// The newest revision is kept within the revision history limit after spec.target.immutable is unset.
+	expected := []string{"app", "app-0000000002"}
+	if diff := cmp.Diff(expected, got); diff != "" {
+		t.Errorf("remaining secrets diff (-expect, +got)\n%s", diff)
+	}
+}
@@ -2,180 +2,840 @@ package controller
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"time"
 
-	"github.com/GoogleCloudPlatform/berglas/pkg/berglas"
 	batchv1alpha1 "github.com/kitagry/berglas-secret-controller/api/v1alpha1"
+	"github.com/kitagry/berglas-secret-controller/internal/dataformat"
+	"github.com/kitagry/berglas-secret-controller/internal/provider"
+	"github.com/kitagry/berglas-secret-controller/internal/secrettemplate"
+	"github.com/kitagry/berglas-secret-controller/internal/tracing"
 	v1 "k8s.io/api/core/v1"
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/util/csaupgrade"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 const (
 	secretAnnotationKey = "kitagry.github.io/berglasSecret"
 	secretVersionKey    = "kitagry.github.io/berglasSecretVersion"
+	secretSpecHashKey   = "kitagry.github.io/berglasSecretSpecHash"
+	// secretDataFromKeysKey has the Secret keys expanded from each DataFrom, so that they can be kept by partialSync.
+	secretDataFromKeysKey = "kitagry.github.io/berglasSecretDataFromKeys"
+
+	// fieldManager is the field manager name used for server-side apply of Secrets.
+	fieldManager = "berglas-secret-controller"
+	// maxFieldManagerLength is the maximum length of field manager names accepted by the API server.
+	maxFieldManagerLength = 128
+
+	// dataFromVersionPrefix is the prefix of the keys of spec.dataFrom in the version annotation.
+	dataFromVersionPrefix = "dataFrom/"
 
 	reconcileRetryCount = 3
 )
 
-func (r *BerglasSecretReconciler) reconcileSecret(ctx context.Context, req ctrl.Request, bs *batchv1alpha1.BerglasSecret) error {
-	var secret v1.Secret
-	err := r.Get(ctx, req.NamespacedName, &secret)
+// secretManager identifies the writer of Secret: the field manager of server-side apply,
+// and the keys of the annotations which record how Secret was built.
+type secretManager struct {
+	fieldManager    string
+	dataKey         string
+	versionKey      string
+	specHashKey     string
+	dataFromKeysKey string
+}
+
+// ownerManager writes the Secrets which belong to a single owner, i.e. with creationPolicy Owner and Orphan.
+var ownerManager = secretManager{
+	fieldManager:    fieldManager,
+	dataKey:         secretAnnotationKey,
+	versionKey:      secretVersionKey,
+	specHashKey:     secretSpecHashKey,
+	dataFromKeysKey: secretDataFromKeysKey,
+}
+
+// managerFor returns the secretManager of owner.
+// With Merge, several owners can write into the same Secret, so each of them has its own field manager and annotations,
+// and the apply of one owner neither prunes the keys of the others nor changes their annotations.
+func managerFor(owner metav1.Object, policy batchv1alpha1.CreationPolicy) secretManager {
+	if policy != batchv1alpha1.CreationPolicyMerge {
+		return ownerManager
+	}
+
+	id := owner.GetName()
+	if owner.GetNamespace() != "" {
+		id = owner.GetNamespace() + "/" + id
+	}
+	sum := sha256.Sum256([]byte(id))
+	hash := hex.EncodeToString(sum[:])[:revisionHashLength]
+	manager := fieldManager + "/" + id
+	if len(manager) > maxFieldManagerLength {
+		manager = fieldManager + "/" + hash
+	}
+	// The name part of annotation keys is at most 63 characters, so they are suffixed with the hash of owner.
+	return secretManager{
+		fieldManager:    manager,
+		dataKey:         secretAnnotationKey + "-" + hash,
+		versionKey:      secretVersionKey + "-" + hash,
+		specHashKey:     secretSpecHashKey + "-" + hash,
+		dataFromKeysKey: secretDataFromKeysKey + "-" + hash,
+	}
+}
+
+// reconcileSecret syncs Secret with bs, and returns the synced Secret.
+func (r *BerglasSecretReconciler) reconcileSecret(ctx context.Context, bs *batchv1alpha1.BerglasSecret) (*v1.Secret, error) {
+	if bs.Spec.Target.Immutable {
+		return r.reconcileRevision(ctx, bs)
+	}
+
+	w := r.newSecretWriter(bs, &bs.Spec, &bs.Status)
+	synced, err := w.sync(ctx, types.NamespacedName{Namespace: bs.Namespace, Name: secretName(bs)})
+	// With partialSync, Secret is synced even if some values fail.
+	err = errors.Join(err, w.partialErr())
+	if synced == nil {
+		return nil, err
+	}
+
+	if err := r.deleteOldSecrets(ctx, bs); err != nil {
+		return nil, err
+	}
+	return synced, err
+}
+
+// secretName returns the name of Secret generated from bs.
+func secretName(bs *batchv1alpha1.BerglasSecret) string {
+	if bs.Spec.Target.Name != "" {
+		return bs.Spec.Target.Name
+	}
+	return bs.Name
+}
+
+// deleteOldSecrets deletes the Secrets owned by bs whose name is not the current target name,
+// except for the newest revisions within the revision history limit.
+func (r *BerglasSecretReconciler) deleteOldSecrets(ctx context.Context, bs *batchv1alpha1.BerglasSecret) error {
+	var secrets v1.SecretList
+	err := r.List(ctx, &secrets, client.InNamespace(bs.Namespace), client.MatchingFields{ownerControllerField: bs.Name})
+	if err != nil {
+		return fmt.Errorf("failed to list owned secrets: %w", err)
+	}
+
+	name := secretName(bs)
+	var old, revisions []v1.Secret
+	for _, secret := range secrets.Items {
+		if secret.Name == name || !metav1.IsControlledBy(&secret, bs) {
+			continue
+		}
+		// The revisions written while spec.target.immutable was true are kept within the revision history limit,
+		// so that workloads which still reference them can be rolled back.
+		if getOrDefault(secret.Immutable, false) {
+			revisions = append(revisions, secret)
+			continue
+		}
+		old = append(old, secret)
+	}
+	old = append(old, expiredRevisions(revisions, bs.Spec.Target.RevisionHistoryLimit)...)
+
+	for _, secret := range old {
+		err := r.Delete(ctx, &secret, client.Preconditions{UID: &secret.UID})
+		if err != nil && !k8serrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete old secret %s: %w", secret.Name, err)
+		}
+	}
+	return nil
+}
+
+// secretWriter writes the Secrets built from spec for owner.
+// The versions and the values of spec are resolved at most once,
+// so that they are shared by the Secrets which ClusterBerglasSecret writes into each namespace.
+type secretWriter struct {
+	*BerglasSecretReconciler
+	owner client.Object
+	spec  *batchv1alpha1.BerglasSecretSpec
+	// force writes the Secrets even if they are up to date.
+	force   bool
+	manager secretManager
+
+	versions    map[string]string
+	versionsErr error
+	values      *resolvedValues
+	// err prevents all Secrets from being written, e.g. a value which fails without partialSync.
+	err error
+}
+
+// newSecretWriter returns the secretWriter for owner, whose spec and status are spec and status.
+func (r *BerglasSecretReconciler) newSecretWriter(owner client.Object, spec *batchv1alpha1.BerglasSecretSpec, status *batchv1alpha1.BerglasSecretStatus) *secretWriter {
+	return &secretWriter{
+		BerglasSecretReconciler: r,
+		owner:                   owner,
+		spec:                    spec,
+		force:                   forceSyncToken(owner, status) != "",
+		manager:                 managerFor(owner, spec.Target.CreationPolicy),
+	}
+}
+
+// sync writes the Secret named key when it is changed, records the event and reloads the workloads.
+// It returns the synced Secret, which is nil when Secret isn't synced.
+// The errors of the values which fail with partialSync are not returned, but reported by partialErr.
+func (w *secretWriter) sync(ctx context.Context, key types.NamespacedName) (*v1.Secret, error) {
+	var current v1.Secret
+	err := w.Get(ctx, key, &current)
 	if k8serrors.IsNotFound(err) {
-		return r.createSecret(ctx, req, bs)
+		if w.spec.Target.CreationPolicy == batchv1alpha1.CreationPolicyMerge {
+			return nil, errMergeTargetNotFound(key.Name)
+		}
+		desired, err := w.build(ctx, key, nil)
+		if err != nil {
+			return nil, err
+		}
+		if err := w.applySecret(ctx, desired); err != nil {
+			return nil, err
+		}
+		w.recordCreated(w.owner, desired)
+		return desired, nil
 	} else if err != nil {
-		return err
+		return nil, err
 	}
 
-	return r.updateSecret(ctx, req, bs, &secret)
-}
+	if err := checkAdoption(w.owner, &w.spec.Target, &current); err != nil {
+		return nil, err
+	}
+	// Secrets which are not written yet don't have the annotations to be compared,
+	// and orphaned Secrets need the owner reference again.
+	if w.manager.isManaged(&current) && !isOrphaned(&current) && !w.force {
+		changed, err := w.isChanged(ctx, &current)
+		if err != nil {
+			return nil, err
+		}
+		if !changed {
+			w.recordUnchanged(w.owner, &current)
+			return &current, w.reloadWorkloads(ctx, &current)
+		}
+	}
 
-func (r *BerglasSecretReconciler) createSecret(ctx context.Context, req ctrl.Request, bs *batchv1alpha1.BerglasSecret) error {
-	data, err := r.resolveBerglasSchemas(ctx, bs.Spec.Data)
+	desired, err := w.build(ctx, key, &current)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if err := w.replaceSecret(ctx, &current, desired); err != nil {
+		return nil, err
+	}
+	if isOrphaned(&current) {
+		if err := w.unlabelOrphaned(ctx, &current); err != nil {
+			return nil, err
+		}
 	}
+	w.recordUpdated(w.owner, &current, desired, w.spec.Target.CreationPolicy)
+	// desired has the response of apply, so its data has the keys of the other managers as well as current.
+	return desired, w.reloadWorkloads(ctx, desired)
+}
 
-	annotationDataJSON, err := json.Marshal(bs.Spec.Data)
+// build builds the desired Secret named key. The returned object is used as the server-side apply configuration.
+// With partialSync, the values which fail keep the ones in current.
+func (w *secretWriter) build(ctx context.Context, key types.NamespacedName, current *v1.Secret) (*v1.Secret, error) {
+	rv, err := w.resolve(ctx)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	versionData, err := r.createVersionData(ctx, bs)
+	secret, err := buildSecret(w.spec, rv.withLastValues(current, w.manager), w.manager)
 	if err != nil {
-		return fmt.Errorf("failed to create version data: %w", err)
+		return nil, err
 	}
 
-	versionDataJSON, err := json.Marshal(versionData)
+	secret.Name = key.Name
+	secret.Namespace = key.Namespace
+	if err := setOwnerReference(w.owner, secret, w.spec.Target.CreationPolicy, w.Scheme); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// isChanged reports whether secret was built from another spec or other versions of the values.
+func (w *secretWriter) isChanged(ctx context.Context, secret *v1.Secret) (bool, error) {
+	changed, err := w.manager.isSpecChanged(w.spec, secret)
+	if err != nil || changed {
+		return changed, err
+	}
+
+	currentVersionData, err := w.versionData(ctx)
 	if err != nil {
-		return err
+		// With partialSync, the values are resolved again to report the failed ones and keep the others up to date.
+		if allowsPartialSync(w.spec, err) {
+			return true, nil
+		}
+		return false, err
 	}
+	return w.manager.isVersionChanged(secret, currentVersionData)
+}
 
-	secret := &v1.Secret{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      req.Name,
-			Namespace: req.Namespace,
-			Annotations: map[string]string{
-				secretAnnotationKey: string(annotationDataJSON),
-				secretVersionKey:    string(versionDataJSON),
-			},
-		},
-		StringData: data,
+// versionData returns the current versions of the values of spec.
+func (w *secretWriter) versionData(ctx context.Context) (map[string]string, error) {
+	if w.versions == nil {
+		w.versions, w.versionsErr = w.createVersionData(ctx, w.spec)
+		if w.versionsErr != nil && !allowsPartialSync(w.spec, w.versionsErr) {
+			w.err = w.versionsErr
+		}
 	}
-	if err := ctrl.SetControllerReference(bs, secret, r.Scheme); err != nil {
-		return err
+	return w.versions, w.versionsErr
+}
+
+// resolve returns the resolved values of spec.
+func (w *secretWriter) resolve(ctx context.Context) (*resolvedValues, error) {
+	if w.values == nil && w.err == nil {
+		w.values, w.err = w.resolveValues(ctx, w.spec)
 	}
+	return w.values, w.err
+}
 
-	if err := r.Create(ctx, secret); err != nil {
-		return err
+// partialErr returns the keyErrors of the values which fail with partialSync.
+func (w *secretWriter) partialErr() error {
+	if w.values == nil {
+		return nil
+	}
+	return w.values.err
+}
+
+// setOwnerReference sets owner as the controller of secret when policy is Owner.
+func setOwnerReference(owner metav1.Object, secret *v1.Secret, policy batchv1alpha1.CreationPolicy, scheme *runtime.Scheme) error {
+	if policy != "" && policy != batchv1alpha1.CreationPolicyOwner {
+		return nil
+	}
+	return ctrl.SetControllerReference(owner, secret, scheme)
+}
+
+// isManaged reports whether secret was written by m.
+func (m secretManager) isManaged(secret *v1.Secret) bool {
+	_, ok := secret.Annotations[m.dataKey]
+	return ok
+}
+
+// checkAdoption returns a secretError when the existing secret must not be written for owner.
+// Secrets controlled by another object are never written, even with Merge.
+// Otherwise, Secrets which are not written by the controller are taken over only with target.adopt or Merge.
+func checkAdoption(owner metav1.Object, target *batchv1alpha1.SecretTarget, secret *v1.Secret) error {
+	if ref := metav1.GetControllerOf(secret); ref != nil && ref.UID != owner.GetUID() {
+		return &secretError{Reason: reasonAdoptionRefused, Err: fmt.Errorf("secret %s is controlled by %s %s", secret.Name, ref.Kind, ref.Name)}
+	}
+	if target.CreationPolicy == batchv1alpha1.CreationPolicyMerge {
+		return nil
+	}
+	if !ownerManager.isManaged(secret) && !target.Adopt {
+		return &secretError{Reason: reasonAdoptionRefused, Err: fmt.Errorf("secret %s already exists and is not managed by the controller, set spec.target.adopt to take it over", secret.Name)}
 	}
 	return nil
 }
 
-func (r *BerglasSecretReconciler) resolveBerglasSchemas(ctx context.Context, data map[string]string) (map[string]string, error) {
-	result := make(map[string]string, len(data))
-	for key, value := range data {
-		ref, err := berglas.ParseReference(value)
+// errMergeTargetNotFound returns the error for the Secret which doesn't exist with creationPolicy Merge.
+func errMergeTargetNotFound(name string) error {
+	return &secretError{Reason: reasonSecretNotFound, Err: fmt.Errorf("secret %s is not found, creationPolicy Merge requires an existing Secret", name)}
+}
+
+// resolvedValues is the result of resolving the references of BerglasSecretSpec.
+type resolvedValues struct {
+	// data has the keys of Data and the keys expanded from DataFrom.
+	data map[string][]byte
+	// dataFromKeys has the keys expanded from each DataFrom, keyed by dataFrom/<index>.
+	dataFromKeys map[string][]string
+	// dockerConfig is the content of .dockerconfigjson. It is nil when DockerConfig is not set or fails.
+	dockerConfig []byte
+	versions     map[string]string
+	// err has the keyErrors of the values which failed. It is set only with partialSync.
+	err error
+}
+
+// resolveValues resolves the references of spec.
+// With partialSync, the errors of the values are stored in the result instead of being returned.
+func (r *BerglasSecretReconciler) resolveValues(ctx context.Context, spec *batchv1alpha1.BerglasSecretSpec) (*resolvedValues, error) {
+	versions, versionErr := r.createVersionData(ctx, spec)
+	data, dataErr := r.resolveBerglasSchemas(ctx, spec.Data, spec.DataOptions)
+	errs := []error{versionErr, dataErr}
+
+	dataFromKeys := make(map[string][]string, len(spec.DataFrom))
+	for i, df := range spec.DataFrom {
+		versionKey := fmt.Sprintf("%s%d", dataFromVersionPrefix, i)
+		expanded, err := r.expandDataFrom(ctx, versionKey, df)
 		if err != nil {
-			result[key] = value
+			errs = append(errs, err)
 			continue
 		}
+		keys := slices.Sorted(maps.Keys(expanded))
+		if i := slices.IndexFunc(keys, func(key string) bool { _, ok := data[key]; return ok }); i >= 0 {
+			errs = append(errs, &keyError{Key: versionKey, Reason: reasonKeyConflict, Err: fmt.Errorf("key %s conflicts with other keys", keys[i])})
+			continue
+		}
+		maps.Copy(data, expanded)
+		dataFromKeys[versionKey] = keys
+	}
+
+	var dockerConfig []byte
+	if spec.DockerConfig != nil {
+		var err error
+		dockerConfig, err = r.buildDockerConfigJSON(ctx, spec.DockerConfig)
+		errs = append(errs, err)
+	}
+
+	rv := &resolvedValues{data: data, dataFromKeys: dataFromKeys, dockerConfig: dockerConfig, versions: versions}
+	if err := errors.Join(errs...); err != nil {
+		if !allowsPartialSync(spec, err) {
+			return nil, err
+		}
+		rv.err = err
+	}
+	return rv, nil
+}
+
+// allowsPartialSync reports whether Secret can be written in spite of err, keeping the last values of the failed keys.
+func allowsPartialSync(spec *batchv1alpha1.BerglasSecretSpec, err error) bool {
+	return spec.PartialSync && isKeyErrors(err)
+}
+
+// withLastValues returns the copy of rv where the values which failed are replaced with the ones stored in current.
+// Their versions are also replaced, so that they are resolved again when the versions are changed.
+// The values which are not stored in current are omitted.
+func (rv *resolvedValues) withLastValues(current *v1.Secret, m secretManager) *resolvedValues {
+	result := &resolvedValues{
+		data:         maps.Clone(rv.data),
+		dataFromKeys: maps.Clone(rv.dataFromKeys),
+		dockerConfig: rv.dockerConfig,
+		versions:     maps.Clone(rv.versions),
+		err:          rv.err,
+	}
+	if rv.err == nil {
+		return result
+	}
+
+	var lastData map[string][]byte
+	if current != nil {
+		lastData = current.Data
+	}
+	lastVersions := m.secretVersions(current)
+	lastDataFromKeys := m.secretDataFromKeys(current)
+	restore := func(key string) {
+		delete(result.data, key)
+		if value, ok := lastData[key]; ok {
+			result.data[key] = value
+		}
+	}
+
+	dockerConfigFailed := false
+	for _, ke := range keyErrors(rv.err) {
+		result.versions[ke.Key] = lastVersions[ke.Key]
+		switch {
+		case strings.HasPrefix(ke.Key, dataFromVersionPrefix):
+			delete(result.dataFromKeys, ke.Key)
+			for _, key := range lastDataFromKeys[ke.Key] {
+				if _, ok := result.data[key]; ok {
+					continue
+				}
+				restore(key)
+				result.dataFromKeys[ke.Key] = append(result.dataFromKeys[ke.Key], key)
+			}
+		case strings.HasPrefix(ke.Key, dockerConfigVersionPrefix):
+			dockerConfigFailed = true
+		default:
+			restore(ke.Key)
+		}
+	}
+
+	// .dockerconfigjson is built from all fields of DockerConfig, so all of them keep the last values.
+	if dockerConfigFailed {
+		result.dockerConfig = lastData[v1.DockerConfigJsonKey]
+		for key := range result.versions {
+			if strings.HasPrefix(key, dockerConfigVersionPrefix) {
+				result.versions[key] = lastVersions[key]
+			}
+		}
+	}
+	return result
+}
+
+// buildSecret builds the Secret from spec and rv without the name, namespace and owner.
+// The annotations which record how Secret was built have the keys of m.
+func buildSecret(spec *batchv1alpha1.BerglasSecretSpec, rv *resolvedValues, m secretManager) (*v1.Secret, error) {
+	data, err := buildData(spec, rv)
+	if err != nil {
+		return nil, err
+	}
+
+	annotationDataJSON, err := json.Marshal(spec.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	versionDataJSON, err := json.Marshal(rv.versions)
+	if err != nil {
+		return nil, err
+	}
+
+	// annotations of the controller take precedence over the user defined ones.
+	annotations := maps.Clone(spec.Target.Annotations)
+	if annotations == nil {
+		annotations = make(map[string]string, 3)
+	}
+	annotations[m.dataKey] = string(annotationDataJSON)
+	annotations[m.versionKey] = string(versionDataJSON)
+
+	secret := &v1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "Secret",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Labels:      maps.Clone(spec.Target.Labels),
+			Annotations: annotations,
+		},
+		// Data is used instead of StringData so that binary payloads are kept as is.
+		// StringData is also write-only, so server-side apply cannot track the ownership of its keys.
+		Data: data,
+	}
+	// With Merge, the type belongs to the existing Secret.
+	if spec.Target.CreationPolicy != batchv1alpha1.CreationPolicyMerge {
+		secret.Type = spec.Type
+	}
+	if hash := specHash(spec); hash != "" {
+		secret.Annotations[m.specHashKey] = hash
+	}
+	if len(rv.dataFromKeys) > 0 {
+		dataFromKeysJSON, err := json.Marshal(rv.dataFromKeys)
+		if err != nil {
+			return nil, err
+		}
+		secret.Annotations[m.dataFromKeysKey] = string(dataFromKeysJSON)
+	}
+	return secret, nil
+}
+
+// buildData returns the data of Secret which is built from spec and the resolved values.
+func buildData(spec *batchv1alpha1.BerglasSecretSpec, rv *resolvedValues) (map[string][]byte, error) {
+	data := maps.Clone(rv.data)
 
-		var plaintext []byte
-		for range reconcileRetryCount {
-			plaintext, err = r.Berglas.Resolve(ctx, ref.String())
-			if err == nil {
-				break
+	if len(spec.Template) > 0 {
+		rendered, err := secrettemplate.Render(spec.Template, data)
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range rendered {
+			if _, ok := data[key]; ok {
+				return nil, fmt.Errorf("template key %s conflicts with data", key)
 			}
+			data[key] = value
+		}
+	}
+
+	if spec.DockerConfig != nil {
+		if _, ok := data[v1.DockerConfigJsonKey]; ok {
+			return nil, fmt.Errorf("dockerConfig conflicts with %s key", v1.DockerConfigJsonKey)
+		}
+		if rv.dockerConfig != nil {
+			data[v1.DockerConfigJsonKey] = rv.dockerConfig
+		}
+	}
+	return data, nil
+}
+
+// expandDataFrom resolves the reference of df and returns the expanded keys.
+// The returned error is a keyError whose key is versionKey.
+func (r *BerglasSecretReconciler) expandDataFrom(ctx context.Context, versionKey string, df batchv1alpha1.DataFrom) (map[string][]byte, error) {
+	if err := r.Berglas.Validate(df.Ref); err != nil {
+		return nil, &keyError{Key: versionKey, Reason: reasonResolveFailed, Err: fmt.Errorf("invalid reference: %w", err)}
+	}
+	ctx, span := tracing.Start(ctx, "resolveKey", tracing.Key(versionKey), tracing.ReferenceType(df.Ref))
+	payload, err := r.resolve(ctx, df.Ref)
+	tracing.End(span, err)
+	if err != nil {
+		return nil, &keyError{Key: versionKey, Reason: reasonResolveFailed, Err: err}
+	}
+	fields, err := dataformat.Expand(df.Format, payload)
+	if err != nil {
+		return nil, &keyError{Key: versionKey, Reason: reasonParseFailed, Err: err}
+	}
+	fields, err = dataformat.Filter(fields, df.Prefix, df.Include, df.Exclude)
+	if err != nil {
+		return nil, &keyError{Key: versionKey, Reason: reasonParseFailed, Err: err}
+	}
+	// The payload can change after the webhook validated it.
+	for _, key := range slices.Sorted(maps.Keys(fields)) {
+		if msgs := validation.IsConfigMapKey(key); len(msgs) > 0 {
+			return nil, &keyError{Key: versionKey, Reason: reasonParseFailed, Err: fmt.Errorf("invalid key %q: %s", key, strings.Join(msgs, ", "))}
+		}
+	}
+	return fields, nil
+}
+
+// versionedValues returns the values whose versions are tracked, keyed by the name in the version annotation.
+func versionedValues(spec *batchv1alpha1.BerglasSecretSpec) map[string]string {
+	values := make(map[string]string, len(spec.Data))
+	maps.Copy(values, spec.Data)
+	for i, df := range spec.DataFrom {
+		values[fmt.Sprintf("%s%d", dataFromVersionPrefix, i)] = df.Ref
+	}
+	if spec.DockerConfig != nil {
+		maps.Copy(values, dockerConfigValues(spec.DockerConfig))
+	}
+	return values
+}
+
+// specHash returns the hash of the spec fields other than Data which affect the contents of Secret.
+// It returns an empty string when none of them is set, so that secrets created by the previous version are not changed.
+func specHash(spec *batchv1alpha1.BerglasSecretSpec) string {
+	fields := struct {
+		DataOptions  map[string]batchv1alpha1.DataOption `json:"dataOptions,omitempty"`
+		DataFrom     []batchv1alpha1.DataFrom            `json:"dataFrom,omitempty"`
+		Template     map[string]string                   `json:"template,omitempty"`
+		Type         v1.SecretType                       `json:"type,omitempty"`
+		DockerConfig *batchv1alpha1.DockerConfig         `json:"dockerConfig,omitempty"`
+		Labels       map[string]string                   `json:"labels,omitempty"`
+		Annotations  map[string]string                   `json:"annotations,omitempty"`
+		// CreationPolicy changes the owner reference and the type.
+		CreationPolicy batchv1alpha1.CreationPolicy `json:"creationPolicy,omitempty"`
+	}{
+		DataOptions:  spec.DataOptions,
+		DataFrom:     spec.DataFrom,
+		Template:     spec.Template,
+		Type:         spec.Type,
+		DockerConfig: spec.DockerConfig,
+		Labels:       spec.Target.Labels,
+		Annotations:  spec.Target.Annotations,
 
-			// timeout error is retryable
-			var netErr interface{ Timeout() bool }
-			if errors.As(err, &netErr) && netErr.Timeout() {
-				r.Log.Info("timeout error occurred, retrying", "key", key, "value", value)
-				time.Sleep(100 * time.Millisecond)
+		CreationPolicy: spec.Target.CreationPolicy,
+	}
+	b, _ := json.Marshal(fields)
+	if string(b) == "{}" {
+		return ""
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// applySecret creates or updates secret with server-side apply as the field manager of w.
+// Fields which are not included in secret, such as removed keys, are pruned from the object.
+func (w *secretWriter) applySecret(ctx context.Context, secret *v1.Secret) error {
+	return w.Patch(ctx, secret, client.Apply, client.FieldOwner(w.manager.fieldManager), client.ForceOwnership)
+}
+
+// resolveBerglasSchemas resolves each value of data and applies options of the key.
+// It tries all keys, and returns the values of the succeeded keys with the joined keyErrors of the failed keys.
+func (r *BerglasSecretReconciler) resolveBerglasSchemas(ctx context.Context, data map[string]string, options map[string]batchv1alpha1.DataOption) (map[string][]byte, error) {
+	ctx, span := tracing.Start(ctx, "resolveBerglasSchemas")
+	result := make(map[string][]byte, len(data))
+	var errs []error
+	for _, key := range slices.Sorted(maps.Keys(data)) {
+		value := data[key]
+
+		// values which are not references are stored as is
+		plaintext := []byte(value)
+		if err := r.Berglas.Validate(value); err == nil {
+			ctx, span := tracing.Start(ctx, "resolveKey", tracing.Key(key), tracing.ReferenceType(value))
+			plaintext, err = r.resolve(ctx, value)
+			tracing.End(span, err)
+			if options[key].Optional && errors.Is(err, provider.ErrNotFound) {
 				continue
 			}
 			if err != nil {
-				return nil, err
+				errs = append(errs, &keyError{Key: key, Reason: reasonResolveFailed, Err: err})
+				continue
 			}
 		}
 
-		// timeout error occurred 3 times
+		if option, ok := options[key]; ok {
+			extracted, err := dataformat.Extract(plaintext, option.JSONPath, option.Path)
+			if err != nil {
+				errs = append(errs, &keyError{Key: key, Reason: reasonExtractFailed, Err: err})
+				continue
+			}
+			plaintext = extracted
+		}
+		result[key] = plaintext
+	}
+	err := errors.Join(errs...)
+	tracing.End(span, err)
+	return result, err
+}
+
+// resolve resolves ref, retrying on timeout errors.
+func (r *BerglasSecretReconciler) resolve(ctx context.Context, ref string) ([]byte, error) {
+	var plaintext []byte
+	var err error
+	for range reconcileRetryCount {
+		plaintext, err = r.Berglas.Resolve(ctx, ref)
+		if err == nil {
+			return plaintext, nil
+		}
+
+		// timeout error is retryable
+		var netErr interface{ Timeout() bool }
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			r.Log.Info("timeout error occurred, retrying", "value", ref)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		return nil, err
+	}
+
+	// timeout error occurred 3 times
+	return nil, err
+}
+
+// replaceSecret applies desired to the existing current Secret.
+func (w *secretWriter) replaceSecret(ctx context.Context, current, desired *v1.Secret) error {
+	// With Merge, the keys of the other managers are kept, so the Secret is neither recreated nor upgraded.
+	if w.spec.Target.CreationPolicy == batchv1alpha1.CreationPolicyMerge {
+		if getOrDefault(current.Immutable, false) {
+			return fmt.Errorf("secret %s is immutable and cannot be merged", current.Name)
+		}
+		return w.applySecret(ctx, desired)
+	}
+
+	// Immutable fields cannot be changed by apply, so we recreate the secret only in that case.
+	if needsRecreate(current, desired) {
+		err := w.Delete(ctx, current, client.Preconditions{UID: &current.UID})
 		if err != nil {
-			return nil, err
+			return fmt.Errorf("failed to recreate secret in the step of deleting old secret: %w", err)
 		}
+		return w.applySecret(ctx, desired)
+	}
 
-		result[key] = string(plaintext)
+	if err := w.upgradeManagedFields(ctx, current); err != nil {
+		return fmt.Errorf("failed to upgrade managed fields: %w", err)
 	}
-	return result, nil
+	return w.applySecret(ctx, desired)
 }
 
-func (r *BerglasSecretReconciler) updateSecret(ctx context.Context, req ctrl.Request, bs *batchv1alpha1.BerglasSecret, secret *v1.Secret) error {
-	isChanged, err := r.isChanged(ctx, bs, secret)
-	if err != nil {
-		return err
+// needsRecreate reports whether desired cannot be applied to current in place.
+func needsRecreate(current, desired *v1.Secret) bool {
+	if getOrDefault(current.Immutable, false) {
+		return true
 	}
-	if !isChanged {
+	return secretType(current) != secretType(desired)
+}
+
+func secretType(secret *v1.Secret) v1.SecretType {
+	if secret.Type == "" {
+		return v1.SecretTypeOpaque
+	}
+	return secret.Type
+}
+
+// legacyFieldManager is the field manager of Create/Update requests of the previous version of the controller.
+// The API server derives it from the user agent, which starts with the name of this binary.
+var legacyFieldManager = filepath.Base(os.Args[0])
+
+// upgradeManagedFields moves the ownership of fields written by Create/Update requests of the previous version to fieldManager.
+// Secrets created by the previous version of the controller are owned by legacyFieldManager,
+// and without this, keys removed from BerglasSecret would not be pruned by apply.
+func (r *BerglasSecretReconciler) upgradeManagedFields(ctx context.Context, secret *v1.Secret) error {
+	managers := legacyManagers(secret)
+	if managers.Len() == 0 {
 		return nil
 	}
 
-	// When we update both a berglasSecret and a pod which use the berglasSecret to populate environment variables,
-	// the pod might use secret which is not updated yet. So, we delete secret firstly, and then create new secret.
-	err = r.Delete(ctx, secret)
+	patch, err := csaupgrade.UpgradeManagedFieldsPatch(secret, managers, fieldManager)
 	if err != nil {
-		return fmt.Errorf("failed to update secret in the step of deleting old secret: %w", err)
+		return err
 	}
-	return r.createSecret(ctx, req, bs)
+	if patch == nil {
+		return nil
+	}
+	return r.Patch(ctx, secret, client.RawPatch(types.JSONPatchType, patch))
 }
 
-func (r *BerglasSecretReconciler) createVersionData(ctx context.Context, bs *batchv1alpha1.BerglasSecret) (map[string]string, error) {
-	result := make(map[string]string, len(bs.Spec.Data))
-	for key, value := range bs.Spec.Data {
-		ref, err := berglas.ParseReference(value)
-		if err != nil {
+// createVersionData returns the current versions of the values of spec.
+// It tries all values, and returns the joined keyErrors of the failed ones.
+func (r *BerglasSecretReconciler) createVersionData(ctx context.Context, spec *batchv1alpha1.BerglasSecretSpec) (map[string]string, error) {
+	ctx, span := tracing.Start(ctx, "createVersionData")
+	values := versionedValues(spec)
+	result := make(map[string]string, len(values))
+	var errs []error
+	for _, key := range slices.Sorted(maps.Keys(values)) {
+		value := values[key]
+		if err := r.Berglas.Validate(value); err != nil {
+			result[key] = ""
+			continue
+		}
+		ctx, keySpan := tracing.Start(ctx, "versionKey", tracing.Key(key), tracing.ReferenceType(value))
+		v, err := r.Berglas.Version(ctx, value)
+		tracing.End(keySpan, err)
+		if spec.DataOptions[key].Optional && errors.Is(err, provider.ErrNotFound) {
+			// The empty version is changed when the value is created.
 			result[key] = ""
 			continue
 		}
-		v, err := r.Berglas.Version(ctx, ref.String())
 		if err != nil {
-			return nil, err
+			errs = append(errs, &keyError{Key: key, Reason: reasonResolveFailed, Err: fmt.Errorf("failed to get version: %w", err)})
+			continue
 		}
 		result[key] = v
 	}
-	return result, nil
+	err := errors.Join(errs...)
+	tracing.End(span, err)
+	return result, err
 }
 
-func (r *BerglasSecretReconciler) isChanged(ctx context.Context, bs *batchv1alpha1.BerglasSecret, secret *v1.Secret) (bool, error) {
-	annotationDataStr := secret.Annotations[secretAnnotationKey]
+// legacyManagers returns the managers whose fields should be moved to fieldManager.
+// Fields of other managers, such as annotations set by kubectl, are left to them, so that they are not pruned by apply.
+func legacyManagers(secret *v1.Secret) sets.Set[string] {
+	managers := sets.New[string]()
+	for _, f := range secret.ManagedFields {
+		if f.Manager == fieldManager && f.Operation == metav1.ManagedFieldsOperationApply {
+			return nil
+		}
+		if f.Manager == legacyFieldManager && f.Operation == metav1.ManagedFieldsOperationUpdate {
+			managers.Insert(f.Manager)
+		}
+	}
+	return managers
+}
+
+// forceSyncToken returns the value of the force-sync annotation of obj when it is not handled yet.
+func forceSyncToken(obj metav1.Object, status *batchv1alpha1.BerglasSecretStatus) string {
+	token := obj.GetAnnotations()[batchv1alpha1.ForceSyncAnnotation]
+	if token == status.LastForceSyncToken {
+		return ""
+	}
+	return token
+}
+
+// isSpecChanged reports whether secret was built by m from a spec other than spec.
+func (m secretManager) isSpecChanged(spec *batchv1alpha1.BerglasSecretSpec, secret *v1.Secret) (bool, error) {
+	annotationDataStr := secret.Annotations[m.dataKey]
 	var annotationData map[string]string
 	if err := json.Unmarshal([]byte(annotationDataStr), &annotationData); err != nil {
 		return false, fmt.Errorf("failed to get annotation data: %w", err)
 	}
 
-	if !maps.Equal(annotationData, bs.Spec.Data) {
+	if !maps.Equal(annotationData, spec.Data) {
 		return true, nil
 	}
 
-	// This is compatible with the previous version of the controller.
-	versionDataStr := secret.Annotations[secretVersionKey]
-	if versionDataStr == "" {
+	if secret.Annotations[m.specHashKey] != specHash(spec) {
 		return true, nil
 	}
 
+	// This is compatible with the previous version of the controller.
+	return secret.Annotations[m.versionKey] == "", nil
+}
+
+// isVersionChanged reports whether the versions of secret recorded by m differ from currentVersionData.
+func (m secretManager) isVersionChanged(secret *v1.Secret, currentVersionData map[string]string) (bool, error) {
 	var versionData map[string]string
-	if err := json.Unmarshal([]byte(versionDataStr), &versionData); err != nil {
+	if err := json.Unmarshal([]byte(secret.Annotations[m.versionKey]), &versionData); err != nil {
 		return false, fmt.Errorf("failed to get version data: %w", err)
 	}
 
-	currentVersionData, err := r.createVersionData(ctx, bs)
-	if err != nil {
-		return false, err
-	}
 	for key, value := range currentVersionData {
 		if versionData[key] != value {
 			return true, nil
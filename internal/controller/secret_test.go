@@ -2,18 +2,34 @@ package controller
 
 import (
 	"context"
+	"errors"
 	"log"
+	"strings"
 	"testing"
 
 	"github.com/go-logr/stdr"
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 	batchv1alpha1 "github.com/kitagry/berglas-secret-controller/api/v1alpha1"
 	mockcontroller "github.com/kitagry/berglas-secret-controller/internal/controller/mock"
+	"github.com/kitagry/berglas-secret-controller/internal/provider"
 	"go.uber.org/mock/gomock"
+	appsv1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/csaupgrade"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 )
 
+var errNotFound = errors.New("not found")
+
 func TestBerglasSecretReconciler_isChanged(t *testing.T) {
 	tests := map[string]struct {
 		createMockBerglasClient func(ctrl *gomock.Controller) *mockcontroller.MockberglasClient
@@ -61,9 +77,34 @@ func TestBerglasSecretReconciler_isChanged(t *testing.T) {
 			},
 			expectedBool: true,
 		},
+		"When template is changed, should return true": {
+			createMockBerglasClient: func(ctrl *gomock.Controller) *mockcontroller.MockberglasClient {
+				return mockcontroller.NewMockberglasClient(ctrl)
+			},
+			berglasSecret: &batchv1alpha1.BerglasSecret{
+				Spec: batchv1alpha1.BerglasSecretSpec{
+					Data: map[string]string{
+						"some": "value",
+					},
+					Template: map[string]string{
+						"url": "https://{{ .some }}",
+					},
+				},
+			},
+			secret: &v1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{
+						secretAnnotationKey: `{"some":"value"}`,
+						secretVersionKey:    `{}`,
+					},
+				},
+			},
+			expectedBool: true,
+		},
 		"When secretVersion annotation is changed, should return true": {
 			createMockBerglasClient: func(ctrl *gomock.Controller) *mockcontroller.MockberglasClient {
 				controller := mockcontroller.NewMockberglasClient(ctrl)
+				controller.EXPECT().Validate("berglas://storage/secret").Return(nil)
 				controller.EXPECT().Version(gomock.Any(), "berglas://storage/secret").Return("version2", nil)
 				return controller
 			},
@@ -86,7 +127,9 @@ func TestBerglasSecretReconciler_isChanged(t *testing.T) {
 		},
 		"Doesn't check not berglasSchema value": {
 			createMockBerglasClient: func(ctrl *gomock.Controller) *mockcontroller.MockberglasClient {
-				return mockcontroller.NewMockberglasClient(ctrl)
+				controller := mockcontroller.NewMockberglasClient(ctrl)
+				controller.EXPECT().Validate("value").Return(provider.ErrUnsupportedReference)
+				return controller
 			},
 			berglasSecret: &batchv1alpha1.BerglasSecret{
 				Spec: batchv1alpha1.BerglasSecretSpec{
@@ -108,6 +151,7 @@ func TestBerglasSecretReconciler_isChanged(t *testing.T) {
 		"When secretVersion annotation is not changed, should return false": {
 			createMockBerglasClient: func(ctrl *gomock.Controller) *mockcontroller.MockberglasClient {
 				controller := mockcontroller.NewMockberglasClient(ctrl)
+				controller.EXPECT().Validate("berglas://storage/secret").Return(nil)
 				controller.EXPECT().Version(gomock.Any(), "berglas://storage/secret").Return("version", nil)
 				return controller
 			},
@@ -134,8 +178,9 @@ func TestBerglasSecretReconciler_isChanged(t *testing.T) {
 		t.Run(n, func(t *testing.T) {
 			berglasClient := tt.createMockBerglasClient(gomock.NewController(t))
 			reconciler := &BerglasSecretReconciler{Berglas: berglasClient}
+			w := reconciler.newSecretWriter(tt.berglasSecret, &tt.berglasSecret.Spec, &tt.berglasSecret.Status)
 
-			isChanged, err := reconciler.isChanged(context.Background(), tt.berglasSecret, tt.secret)
+			isChanged, err := w.isChanged(context.Background(), tt.secret)
 			if err != nil {
 				t.Fatalf("unexpected error: %v", err)
 			}
@@ -149,9 +194,10 @@ func TestBerglasSecretReconciler_isChanged(t *testing.T) {
 func TestBerglasSecretReconciler_resolveBerglasSchemas(t *testing.T) {
 	tests := map[string]struct {
 		data                    map[string]string
+		options                 map[string]batchv1alpha1.DataOption
 		createMockBerglasClient func(ctrl *gomock.Controller) *mockcontroller.MockberglasClient
 
-		expected    map[string]string
+		expected    map[string][]byte
 		expectedErr error
 	}{
 		"Retry timeout error": {
@@ -160,13 +206,32 @@ func TestBerglasSecretReconciler_resolveBerglasSchemas(t *testing.T) {
 			},
 			createMockBerglasClient: func(ctrl *gomock.Controller) *mockcontroller.MockberglasClient {
 				controller := mockcontroller.NewMockberglasClient(ctrl)
+				controller.EXPECT().Validate("berglas://storage/secret").Return(nil)
 				first := controller.EXPECT().Resolve(gomock.Any(), "berglas://storage/secret").Return([]byte(""), context.DeadlineExceeded)
 				second := controller.EXPECT().Resolve(gomock.Any(), "berglas://storage/secret").Return([]byte("got"), nil)
 				gomock.InOrder(first, second)
 				return controller
 			},
-			expected: map[string]string{
-				"some": "got",
+			expected: map[string][]byte{
+				"some": []byte("got"),
+			},
+			expectedErr: nil,
+		},
+		"Keep binary payload as is": {
+			data: map[string]string{
+				"some":    "berglas://storage/secret",
+				"literal": "value",
+			},
+			createMockBerglasClient: func(ctrl *gomock.Controller) *mockcontroller.MockberglasClient {
+				controller := mockcontroller.NewMockberglasClient(ctrl)
+				controller.EXPECT().Validate("berglas://storage/secret").Return(nil)
+				controller.EXPECT().Validate("value").Return(provider.ErrUnsupportedReference)
+				controller.EXPECT().Resolve(gomock.Any(), "berglas://storage/secret").Return([]byte{0x1f, 0x8b, 0xff, 0xfe, 0x00}, nil)
+				return controller
+			},
+			expected: map[string][]byte{
+				"some":    {0x1f, 0x8b, 0xff, 0xfe, 0x00},
+				"literal": []byte("value"),
 			},
 			expectedErr: nil,
 		},
@@ -176,12 +241,89 @@ func TestBerglasSecretReconciler_resolveBerglasSchemas(t *testing.T) {
 			},
 			createMockBerglasClient: func(ctrl *gomock.Controller) *mockcontroller.MockberglasClient {
 				controller := mockcontroller.NewMockberglasClient(ctrl)
+				controller.EXPECT().Validate("berglas://storage/secret").Return(nil)
 				controller.EXPECT().Resolve(gomock.Any(), "berglas://storage/secret").Return([]byte(""), context.DeadlineExceeded).Times(reconcileRetryCount)
 				return controller
 			},
-			expected:    nil,
+			expected:    map[string][]byte{},
 			expectedErr: context.DeadlineExceeded,
 		},
+		"Extract fields with options": {
+			data: map[string]string{
+				"user":     "sm://project/db",
+				"password": "sm://project/db",
+			},
+			options: map[string]batchv1alpha1.DataOption{
+				"user":     {JSONPath: "{.user}"},
+				"password": {Path: "password"},
+			},
+			createMockBerglasClient: func(ctrl *gomock.Controller) *mockcontroller.MockberglasClient {
+				controller := mockcontroller.NewMockberglasClient(ctrl)
+				controller.EXPECT().Validate("sm://project/db").Return(nil).Times(2)
+				controller.EXPECT().Resolve(gomock.Any(), "sm://project/db").Return([]byte(`{"user":"admin","password":"p@ss"}`), nil).Times(2)
+				return controller
+			},
+			expected: map[string][]byte{
+				"user":     []byte("admin"),
+				"password": []byte("p@ss"),
+			},
+			expectedErr: nil,
+		},
+		"Return the succeeded keys with the errors of the failed keys": {
+			data: map[string]string{
+				"user":     "sm://project/user",
+				"password": "sm://project/password",
+			},
+			createMockBerglasClient: func(ctrl *gomock.Controller) *mockcontroller.MockberglasClient {
+				controller := mockcontroller.NewMockberglasClient(ctrl)
+				controller.EXPECT().Validate(gomock.Any()).Return(nil).Times(2)
+				controller.EXPECT().Resolve(gomock.Any(), "sm://project/user").Return([]byte("admin"), nil)
+				controller.EXPECT().Resolve(gomock.Any(), "sm://project/password").Return(nil, errNotFound)
+				return controller
+			},
+			expected: map[string][]byte{
+				"user": []byte("admin"),
+			},
+			expectedErr: errNotFound,
+		},
+		"Omit optional keys which are not found": {
+			data: map[string]string{
+				"user": "sm://project/user",
+				"flag": "sm://project/flag",
+			},
+			options: map[string]batchv1alpha1.DataOption{
+				"flag": {Optional: true},
+			},
+			createMockBerglasClient: func(ctrl *gomock.Controller) *mockcontroller.MockberglasClient {
+				controller := mockcontroller.NewMockberglasClient(ctrl)
+				controller.EXPECT().Validate(gomock.Any()).Return(nil).Times(2)
+				controller.EXPECT().Resolve(gomock.Any(), "sm://project/user").Return([]byte("admin"), nil)
+				controller.EXPECT().Resolve(gomock.Any(), "sm://project/flag").Return(nil, provider.NotFound(errNotFound))
+				return controller
+			},
+			expected: map[string][]byte{
+				"user": []byte("admin"),
+			},
+			expectedErr: nil,
+		},
+		"Return errors of all failed keys": {
+			data: map[string]string{
+				"user":     "sm://project/db",
+				"password": "sm://project/password",
+			},
+			options: map[string]batchv1alpha1.DataOption{
+				"user": {Path: "unknown"},
+			},
+			createMockBerglasClient: func(ctrl *gomock.Controller) *mockcontroller.MockberglasClient {
+				controller := mockcontroller.NewMockberglasClient(ctrl)
+				controller.EXPECT().Validate(gomock.Any()).Return(nil).Times(2)
+				controller.EXPECT().Resolve(gomock.Any(), "sm://project/db").Return([]byte(`{"user":"admin"}`), nil)
+				controller.EXPECT().Resolve(gomock.Any(), "sm://project/password").Return(nil, errNotFound)
+				return controller
+			},
+			expected:    map[string][]byte{},
+			expectedErr: errNotFound,
+		},
 	}
 
 	for n, tt := range tests {
@@ -189,8 +331,8 @@ func TestBerglasSecretReconciler_resolveBerglasSchemas(t *testing.T) {
 			berglasClient := tt.createMockBerglasClient(gomock.NewController(t))
 			reconciler := &BerglasSecretReconciler{Berglas: berglasClient, Log: stdr.New(log.Default())}
 
-			got, err := reconciler.resolveBerglasSchemas(context.Background(), tt.data)
-			if err != tt.expectedErr {
+			got, err := reconciler.resolveBerglasSchemas(context.Background(), tt.data, tt.options)
+			if !errors.Is(err, tt.expectedErr) {
 				t.Errorf("expected %v, but got %v", tt.expectedErr, err)
 			}
 			if diff := cmp.Diff(tt.expected, got); diff != "" {
@@ -199,3 +341,509 @@ func TestBerglasSecretReconciler_resolveBerglasSchemas(t *testing.T) {
 		})
 	}
 }
+
+func TestBerglasSecretReconciler_expandDataFrom(t *testing.T) {
+	tests := map[string]struct {
+		dataFrom batchv1alpha1.DataFrom
+		payload  string
+
+		expected       map[string][]byte
+		expectedReason string
+	}{
+		"Expand keys": {
+			dataFrom: batchv1alpha1.DataFrom{Ref: "sm://project/db", Prefix: "DB_"},
+			payload:  `{"user":"admin"}`,
+			expected: map[string][]byte{
+				"DB_user": []byte("admin"),
+			},
+		},
+		"Return error when the expanded key is invalid": {
+			dataFrom:       batchv1alpha1.DataFrom{Ref: "sm://project/db"},
+			payload:        `{"user name":"admin"}`,
+			expectedReason: reasonParseFailed,
+		},
+	}
+
+	for n, tt := range tests {
+		t.Run(n, func(t *testing.T) {
+			berglasClient := mockcontroller.NewMockberglasClient(gomock.NewController(t))
+			berglasClient.EXPECT().Validate(tt.dataFrom.Ref).Return(nil)
+			berglasClient.EXPECT().Resolve(gomock.Any(), tt.dataFrom.Ref).Return([]byte(tt.payload), nil)
+			reconciler := &BerglasSecretReconciler{Berglas: berglasClient, Log: stdr.New(log.Default())}
+
+			got, err := reconciler.expandDataFrom(context.Background(), "dataFrom/0", tt.dataFrom)
+			var reason string
+			if err != nil {
+				var keyErr *keyError
+				if !errors.As(err, &keyErr) {
+					t.Fatalf("expected keyError, but got %v", err)
+				}
+				reason = keyErr.Reason
+			}
+			if reason != tt.expectedReason {
+				t.Errorf("expected reason %q, but got %q (%v)", tt.expectedReason, reason, err)
+			}
+			if diff := cmp.Diff(tt.expected, got); diff != "" {
+				t.Errorf("expandDataFrom result diff (-expect, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestBerglasSecretReconciler_buildData(t *testing.T) {
+	tests := map[string]struct {
+		spec                    *batchv1alpha1.BerglasSecretSpec
+		createMockBerglasClient func(ctrl *gomock.Controller) *mockcontroller.MockberglasClient
+		expected                map[string][]byte
+		expectedError           bool
+	}{
+		"expand dataFrom and render template with the expanded keys": {
+			spec: &batchv1alpha1.BerglasSecretSpec{
+				Data: map[string]string{
+					"host": "localhost",
+				},
+				DataFrom: []batchv1alpha1.DataFrom{
+					{Ref: "sm://project/db", Prefix: "db_", Exclude: []string{"port"}},
+				},
+				Template: map[string]string{
+					"url": "postgres://{{ .db_user }}:{{ .db_password }}@{{ .host }}/app",
+				},
+			},
+			createMockBerglasClient: func(ctrl *gomock.Controller) *mockcontroller.MockberglasClient {
+				controller := mockcontroller.NewMockberglasClient(ctrl)
+				controller.EXPECT().Validate("localhost").Return(provider.ErrUnsupportedReference).Times(2)
+				controller.EXPECT().Validate("sm://project/db").Return(nil).Times(2)
+				controller.EXPECT().Version(gomock.Any(), "sm://project/db").Return("1", nil)
+				controller.EXPECT().Resolve(gomock.Any(), "sm://project/db").Return([]byte(`{"user":"admin","password":"p@ss","port":5432}`), nil)
+				return controller
+			},
+			expected: map[string][]byte{
+				"host":        []byte("localhost"),
+				"db_user":     []byte("admin"),
+				"db_password": []byte("p@ss"),
+				"url":         []byte("postgres://admin:p@ss@localhost/app"),
+			},
+		},
+		"return error when dataFrom key conflicts with data": {
+			spec: &batchv1alpha1.BerglasSecretSpec{
+				Data: map[string]string{
+					"user": "admin",
+				},
+				DataFrom: []batchv1alpha1.DataFrom{
+					{Ref: "sm://project/db"},
+				},
+			},
+			createMockBerglasClient: func(ctrl *gomock.Controller) *mockcontroller.MockberglasClient {
+				controller := mockcontroller.NewMockberglasClient(ctrl)
+				controller.EXPECT().Validate("admin").Return(provider.ErrUnsupportedReference).Times(2)
+				controller.EXPECT().Validate("sm://project/db").Return(nil).Times(2)
+				controller.EXPECT().Version(gomock.Any(), "sm://project/db").Return("1", nil)
+				controller.EXPECT().Resolve(gomock.Any(), "sm://project/db").Return([]byte(`{"user":"root"}`), nil)
+				return controller
+			},
+			expectedError: true,
+		},
+		"write the other keys with partialSync": {
+			spec: &batchv1alpha1.BerglasSecretSpec{
+				Data: map[string]string{
+					"user":     "sm://project/user",
+					"password": "sm://project/password",
+				},
+				PartialSync: true,
+			},
+			createMockBerglasClient: func(ctrl *gomock.Controller) *mockcontroller.MockberglasClient {
+				controller := mockcontroller.NewMockberglasClient(ctrl)
+				controller.EXPECT().Validate(gomock.Any()).Return(nil).Times(4)
+				controller.EXPECT().Version(gomock.Any(), "sm://project/user").Return("1", nil)
+				controller.EXPECT().Version(gomock.Any(), "sm://project/password").Return("", errNotFound)
+				controller.EXPECT().Resolve(gomock.Any(), "sm://project/user").Return([]byte("admin"), nil)
+				controller.EXPECT().Resolve(gomock.Any(), "sm://project/password").Return(nil, errNotFound)
+				return controller
+			},
+			expected: map[string][]byte{
+				"user": []byte("admin"),
+			},
+		},
+	}
+
+	for n, tt := range tests {
+		t.Run(n, func(t *testing.T) {
+			berglasClient := tt.createMockBerglasClient(gomock.NewController(t))
+			reconciler := &BerglasSecretReconciler{Berglas: berglasClient, Log: stdr.New(log.Default())}
+
+			var got map[string][]byte
+			rv, err := reconciler.resolveValues(context.Background(), tt.spec)
+			if err == nil {
+				got, err = buildData(tt.spec, rv)
+			}
+			if (err != nil) != tt.expectedError {
+				t.Errorf("expected error %v, but got %v", tt.expectedError, err)
+			}
+			if diff := cmp.Diff(tt.expected, got); diff != "" {
+				t.Errorf("buildData result diff (-expect, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestResolvedValues_withLastValues(t *testing.T) {
+	current := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{
+				secretVersionKey:      `{"password":"1","dataFrom/0":"2","dockerConfig/password":"3","dockerConfig/username":"4"}`,
+				secretDataFromKeysKey: `{"dataFrom/0":["db_host","db_port"]}`,
+			},
+		},
+		Data: map[string][]byte{
+			"password":             []byte("old"),
+			"db_host":              []byte("localhost"),
+			"db_port":              []byte("5432"),
+			v1.DockerConfigJsonKey: []byte(`{"auths":{}}`),
+		},
+	}
+
+	tests := map[string]struct {
+		rv       *resolvedValues
+		current  *v1.Secret
+		expected *resolvedValues
+	}{
+		"keep the values when there is no error": {
+			rv: &resolvedValues{
+				data:     map[string][]byte{"password": []byte("new")},
+				versions: map[string]string{"password": "5"},
+			},
+			current: current,
+			expected: &resolvedValues{
+				data:     map[string][]byte{"password": []byte("new")},
+				versions: map[string]string{"password": "5"},
+			},
+		},
+		"replace the failed values with the last ones": {
+			rv: &resolvedValues{
+				data:         map[string][]byte{"user": []byte("admin")},
+				dataFromKeys: map[string][]string{},
+				dockerConfig: nil,
+				versions:     map[string]string{"user": "", "password": "5", "dataFrom/0": "6", "dockerConfig/password": "", "dockerConfig/username": "7"},
+				err: errors.Join(
+					&keyError{Key: "password", Reason: reasonResolveFailed, Err: errNotFound},
+					&keyError{Key: "dataFrom/0", Reason: reasonParseFailed, Err: errors.New("invalid json")},
+					&keyError{Key: "dockerConfig/password", Reason: reasonResolveFailed, Err: errNotFound},
+				),
+			},
+			current: current,
+			expected: &resolvedValues{
+				data: map[string][]byte{
+					"user":     []byte("admin"),
+					"password": []byte("old"),
+					"db_host":  []byte("localhost"),
+					"db_port":  []byte("5432"),
+				},
+				dataFromKeys: map[string][]string{"dataFrom/0": {"db_host", "db_port"}},
+				dockerConfig: []byte(`{"auths":{}}`),
+				versions:     map[string]string{"user": "", "password": "1", "dataFrom/0": "2", "dockerConfig/password": "3", "dockerConfig/username": "4"},
+			},
+		},
+		"omit the failed values which have never been stored": {
+			rv: &resolvedValues{
+				data:     map[string][]byte{"user": []byte("admin")},
+				versions: map[string]string{"user": "", "password": "5"},
+				err:      &keyError{Key: "password", Reason: reasonResolveFailed, Err: errNotFound},
+			},
+			current: nil,
+			expected: &resolvedValues{
+				data:     map[string][]byte{"user": []byte("admin")},
+				versions: map[string]string{"user": "", "password": ""},
+			},
+		},
+	}
+
+	for n, tt := range tests {
+		t.Run(n, func(t *testing.T) {
+			got := tt.rv.withLastValues(tt.current, ownerManager)
+
+			opts := []cmp.Option{cmp.AllowUnexported(resolvedValues{}), cmpopts.IgnoreFields(resolvedValues{}, "err"), cmpopts.EquateEmpty()}
+			if diff := cmp.Diff(tt.expected, got, opts...); diff != "" {
+				t.Errorf("withLastValues result diff (-expect, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSecretWriter_sync(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = batchv1alpha1.AddToScheme(scheme)
+
+	cbs := &batchv1alpha1.ClusterBerglasSecret{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", UID: types.UID("cbs-uid")},
+		Spec: batchv1alpha1.ClusterBerglasSecretSpec{
+			BerglasSecretSpec: batchv1alpha1.BerglasSecretSpec{
+				Data: map[string]string{"password": "sm://project/password"},
+			},
+		},
+	}
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "app",
+			Namespace:   "a",
+			Annotations: map[string]string{batchv1alpha1.ReloadAnnotation: "true"},
+		},
+		Spec: appsv1.DeploymentSpec{
+			Template: v1.PodTemplateSpec{
+				Spec: v1.PodSpec{
+					Containers: []v1.Container{{Name: "app", EnvFrom: []v1.EnvFromSource{{SecretRef: &v1.SecretEnvSource{LocalObjectReference: v1.LocalObjectReference{Name: "app"}}}}}},
+				},
+			},
+		},
+	}
+	objects := []client.Object{deployment}
+	for _, namespace := range []string{"a", "b"} {
+		secret := &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "app",
+				Namespace: namespace,
+				Annotations: map[string]string{
+					secretAnnotationKey: `{"password":"sm://project/password"}`,
+					secretVersionKey:    `{"password":"1"}`,
+				},
+			},
+			Data: map[string][]byte{"password": []byte("p@ss")},
+		}
+		if err := setOwnerReference(cbs, secret, batchv1alpha1.CreationPolicyOwner, scheme); err != nil {
+			t.Fatal(err)
+		}
+		objects = append(objects, secret)
+	}
+
+	berglasClient := mockcontroller.NewMockberglasClient(gomock.NewController(t))
+	// The versions are fetched once for all Secrets.
+	berglasClient.EXPECT().Validate("sm://project/password").Return(nil)
+	berglasClient.EXPECT().Version(gomock.Any(), "sm://project/password").Return("1", nil)
+	r := &BerglasSecretReconciler{
+		Client:   fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build(),
+		Scheme:   scheme,
+		Berglas:  berglasClient,
+		Recorder: record.NewFakeRecorder(10),
+		// The workloads which failed to be reloaded are retried even if Secret is unchanged.
+		ReloadWorkloads: true,
+	}
+
+	w := r.newSecretWriter(cbs, &cbs.Spec.BerglasSecretSpec, &cbs.Status.BerglasSecretStatus)
+	for _, namespace := range []string{"a", "b"} {
+		got, err := w.sync(context.Background(), types.NamespacedName{Namespace: namespace, Name: "app"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got == nil || got.Namespace != namespace {
+			t.Errorf("expected the Secret in %s, but got %v", namespace, got)
+		}
+	}
+
+	if err := r.Get(context.Background(), client.ObjectKeyFromObject(deployment), deployment); err != nil {
+		t.Fatal(err)
+	}
+	if got := deployment.Spec.Template.Annotations[checksumAnnotation("app")]; got != dataHash(map[string][]byte{"password": []byte("p@ss")}) {
+		t.Errorf("the checksum should be set to the unchanged Secret, but got %q", got)
+	}
+}
+
+func TestNeedsRecreate(t *testing.T) {
+	tests := map[string]struct {
+		current  *v1.Secret
+		desired  *v1.Secret
+		expected bool
+	}{
+		"When type is not changed, should return false": {
+			current:  &v1.Secret{Type: v1.SecretTypeOpaque},
+			desired:  &v1.Secret{},
+			expected: false,
+		},
+		"When type is changed, should return true": {
+			current:  &v1.Secret{Type: v1.SecretTypeOpaque},
+			desired:  &v1.Secret{Type: v1.SecretTypeTLS},
+			expected: true,
+		},
+		"When current secret is immutable, should return true": {
+			current:  &v1.Secret{Type: v1.SecretTypeOpaque, Immutable: toPtr(true)},
+			desired:  &v1.Secret{},
+			expected: true,
+		},
+	}
+
+	for n, tt := range tests {
+		t.Run(n, func(t *testing.T) {
+			got := needsRecreate(tt.current, tt.desired)
+			if got != tt.expected {
+				t.Errorf("expected %v, but got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestForceSyncToken(t *testing.T) {
+	tests := map[string]struct {
+		annotations map[string]string
+		status      *batchv1alpha1.BerglasSecretStatus
+		expected    string
+	}{
+		"no annotation": {
+			annotations: nil,
+			status:      &batchv1alpha1.BerglasSecretStatus{},
+			expected:    "",
+		},
+		"new token": {
+			annotations: map[string]string{batchv1alpha1.ForceSyncAnnotation: "2026-01-02T00:00:00Z"},
+			status:      &batchv1alpha1.BerglasSecretStatus{LastForceSyncToken: "2026-01-01T00:00:00Z"},
+			expected:    "2026-01-02T00:00:00Z",
+		},
+		"handled token": {
+			annotations: map[string]string{batchv1alpha1.ForceSyncAnnotation: "2026-01-01T00:00:00Z"},
+			status:      &batchv1alpha1.BerglasSecretStatus{LastForceSyncToken: "2026-01-01T00:00:00Z"},
+			expected:    "",
+		},
+	}
+
+	for n, tt := range tests {
+		t.Run(n, func(t *testing.T) {
+			bs := &batchv1alpha1.BerglasSecret{ObjectMeta: metav1.ObjectMeta{Annotations: tt.annotations}}
+			got := forceSyncToken(bs, tt.status)
+			if got != tt.expected {
+				t.Errorf("expected %q, but got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestManagerFor(t *testing.T) {
+	owner := &batchv1alpha1.BerglasSecret{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"}}
+	if got := managerFor(owner, batchv1alpha1.CreationPolicyOwner); got != ownerManager {
+		t.Errorf("expected ownerManager with Owner, but got %v", got)
+	}
+
+	got := managerFor(owner, batchv1alpha1.CreationPolicyMerge)
+	if got.fieldManager != "berglas-secret-controller/default/app" {
+		t.Errorf("unexpected field manager %q", got.fieldManager)
+	}
+	other := managerFor(&batchv1alpha1.BerglasSecret{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "default"}}, batchv1alpha1.CreationPolicyMerge)
+	if got.fieldManager == other.fieldManager || got.dataKey == other.dataKey || got.versionKey == other.versionKey {
+		t.Errorf("each owner should have its own manager, but got %v and %v", got, other)
+	}
+
+	long := managerFor(&batchv1alpha1.BerglasSecret{ObjectMeta: metav1.ObjectMeta{Name: strings.Repeat("a", 253), Namespace: "default"}}, batchv1alpha1.CreationPolicyMerge)
+	if len(long.fieldManager) > maxFieldManagerLength {
+		t.Errorf("the field manager should be at most %d characters, but got %d", maxFieldManagerLength, len(long.fieldManager))
+	}
+	for _, key := range []string{long.dataKey, long.versionKey, long.specHashKey, long.dataFromKeysKey} {
+		if msgs := validation.IsQualifiedName(key); len(msgs) > 0 {
+			t.Errorf("invalid annotation key %q: %v", key, msgs)
+		}
+	}
+}
+
+func TestCheckAdoption(t *testing.T) {
+	owner := &batchv1alpha1.BerglasSecret{ObjectMeta: metav1.ObjectMeta{Name: "owner", UID: "owner-uid"}}
+	managed := map[string]string{secretAnnotationKey: `{}`}
+	controlledBy := func(uid types.UID) []metav1.OwnerReference {
+		return []metav1.OwnerReference{{Kind: "BerglasSecret", Name: "other", UID: uid, Controller: toPtr(true)}}
+	}
+
+	tests := map[string]struct {
+		target         batchv1alpha1.SecretTarget
+		secret         *v1.Secret
+		expectedReason string
+	}{
+		"managed secret": {
+			target:         batchv1alpha1.SecretTarget{},
+			secret:         &v1.Secret{ObjectMeta: metav1.ObjectMeta{Annotations: managed, OwnerReferences: controlledBy("owner-uid")}},
+			expectedReason: "",
+		},
+		"unmanaged secret is refused": {
+			target:         batchv1alpha1.SecretTarget{},
+			secret:         &v1.Secret{},
+			expectedReason: reasonAdoptionRefused,
+		},
+		"unmanaged secret is adopted": {
+			target:         batchv1alpha1.SecretTarget{Adopt: true},
+			secret:         &v1.Secret{},
+			expectedReason: "",
+		},
+		"secret controlled by another object is refused even with adopt": {
+			target:         batchv1alpha1.SecretTarget{CreationPolicy: batchv1alpha1.CreationPolicyOrphan, Adopt: true},
+			secret:         &v1.Secret{ObjectMeta: metav1.ObjectMeta{Annotations: managed, OwnerReferences: controlledBy("other-uid")}},
+			expectedReason: reasonAdoptionRefused,
+		},
+		"unmanaged secret is merged": {
+			target:         batchv1alpha1.SecretTarget{CreationPolicy: batchv1alpha1.CreationPolicyMerge},
+			secret:         &v1.Secret{},
+			expectedReason: "",
+		},
+		"secret controlled by another object is refused with Merge": {
+			target:         batchv1alpha1.SecretTarget{CreationPolicy: batchv1alpha1.CreationPolicyMerge},
+			secret:         &v1.Secret{ObjectMeta: metav1.ObjectMeta{Annotations: managed, OwnerReferences: controlledBy("other-uid")}},
+			expectedReason: reasonAdoptionRefused,
+		},
+	}
+
+	for n, tt := range tests {
+		t.Run(n, func(t *testing.T) {
+			err := checkAdoption(owner, &tt.target, tt.secret)
+			var got string
+			if err != nil {
+				got = failureReason(err)
+			}
+			if got != tt.expectedReason {
+				t.Errorf("expected reason %q, but got %q (%v)", tt.expectedReason, got, err)
+			}
+		})
+	}
+}
+
+func TestUpgradeManagedFields(t *testing.T) {
+	controllerFields := `{"f:data":{"f:test":{}},"f:metadata":{"f:annotations":{"f:kitagry.github.io/berglasSecret":{}}}}`
+	kubectlFields := `{"f:metadata":{"f:annotations":{"f:example.com/owner":{}}}}`
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test",
+			Namespace: "default",
+			Annotations: map[string]string{
+				secretAnnotationKey: "true",
+				"example.com/owner": "team-a",
+			},
+			ManagedFields: []metav1.ManagedFieldsEntry{
+				{Manager: legacyFieldManager, Operation: metav1.ManagedFieldsOperationUpdate, APIVersion: "v1", FieldsType: "FieldsV1", FieldsV1: &metav1.FieldsV1{Raw: []byte(controllerFields)}},
+				{Manager: "kubectl-annotate", Operation: metav1.ManagedFieldsOperationUpdate, APIVersion: "v1", FieldsType: "FieldsV1", FieldsV1: &metav1.FieldsV1{Raw: []byte(kubectlFields)}},
+			},
+		},
+		Data: map[string][]byte{"test": []byte("value")},
+	}
+
+	managers := legacyManagers(secret)
+	if diff := cmp.Diff([]string{legacyFieldManager}, sets.List(managers)); diff != "" {
+		t.Fatalf("legacyManagers result diff (-expect, +got)\n%s", diff)
+	}
+	if err := csaupgrade.UpgradeManagedFields(secret, managers, fieldManager); err != nil {
+		t.Fatal(err)
+	}
+
+	type entry struct {
+		Manager   string
+		Operation metav1.ManagedFieldsOperationType
+		Fields    string
+	}
+	got := make([]entry, 0, len(secret.ManagedFields))
+	for _, f := range secret.ManagedFields {
+		got = append(got, entry{Manager: f.Manager, Operation: f.Operation, Fields: string(f.FieldsV1.Raw)})
+	}
+	// The annotation of kubectl is still owned by kubectl, so that it is not pruned by the next apply.
+	expected := []entry{
+		{Manager: "kubectl-annotate", Operation: metav1.ManagedFieldsOperationUpdate, Fields: kubectlFields},
+		{Manager: fieldManager, Operation: metav1.ManagedFieldsOperationApply, Fields: controllerFields},
+	}
+	if diff := cmp.Diff(expected, got, cmpopts.SortSlices(func(a, b entry) bool { return a.Manager < b.Manager })); diff != "" {
+		t.Errorf("managed fields diff (-expect, +got)\n%s", diff)
+	}
+
+	if managers := legacyManagers(secret); managers.Len() != 0 {
+		t.Errorf("upgraded Secret should not be upgraded again, but got %v", sets.List(managers))
+	}
+}
@@ -1,32 +1,153 @@
 package controller
 
 import (
+	"encoding/json"
+	"maps"
+	"slices"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
 	batchv1alpha1 "github.com/kitagry/berglas-secret-controller/api/v1alpha1"
 )
 
-func setCondition(status *batchv1alpha1.BerglasSecretStatus, newCondition batchv1alpha1.BerglasSecretCondition) {
-	if status.Conditions == nil {
-		status.Conditions = make([]batchv1alpha1.BerglasSecretCondition, 0, 1)
+// legacyConditionTypes are the condition types written by the previous version of the controller.
+// They don't satisfy the schema of metav1.Condition, so they are removed.
+var legacyConditionTypes = []string{"Available", "Failure"}
+
+// removeLegacyConditions removes the conditions of legacyConditionTypes from status.
+func removeLegacyConditions(status *batchv1alpha1.BerglasSecretStatus) {
+	for _, t := range legacyConditionTypes {
+		meta.RemoveStatusCondition(&status.Conditions, t)
 	}
+}
 
-	if len(status.Conditions) > 0 {
-		// Don't add duplicate conditions
-		lastCondition := status.Conditions[len(status.Conditions)-1]
-		if lastCondition.Status == newCondition.Status && lastCondition.Reason == newCondition.Reason {
-			return
-		}
+// setReadyCondition sets the Ready condition from err, which is the result of the reconciliation.
+func setReadyCondition(status *batchv1alpha1.BerglasSecretStatus, generation int64, err error) {
+	removeLegacyConditions(status)
+	condition := metav1.Condition{
+		Type:               batchv1alpha1.ConditionTypeReady,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: generation,
+		Reason:             batchv1alpha1.ReasonSynced,
+		Message:            "Secret is synced",
+	}
+	if err != nil {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = failureReason(err)
+		condition.Message = err.Error()
 	}
+	meta.SetStatusCondition(&status.Conditions, condition)
+}
 
-	newConditions := filterOutCondition(status.Conditions, newCondition.Type)
-	status.Conditions = append(newConditions, newCondition)
+// setSuspendedCondition sets the Suspended condition when suspend is true, and removes it otherwise.
+func setSuspendedCondition(status *batchv1alpha1.BerglasSecretStatus, generation int64, suspend bool) {
+	removeLegacyConditions(status)
+	if !suspend {
+		meta.RemoveStatusCondition(&status.Conditions, batchv1alpha1.ConditionTypeSuspended)
+		return
+	}
+	meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+		Type:               batchv1alpha1.ConditionTypeSuspended,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: generation,
+		Reason:             batchv1alpha1.ReasonSuspended,
+		Message:            "Syncing is suspended by spec.suspend",
+	})
 }
 
-func filterOutCondition(conditions []batchv1alpha1.BerglasSecretCondition, conditionType batchv1alpha1.BerglasSecretConditionType) []batchv1alpha1.BerglasSecretCondition {
-	newConditions := make([]batchv1alpha1.BerglasSecretCondition, 0)
-	for _, c := range conditions {
-		if c.Type != conditionType {
-			newConditions = append(newConditions, c)
+// keyStatuses returns the status of each value of spec.
+// secret is the synced Secret, and err is the error of the reconciliation.
+// secret is nil when Secret isn't synced, and then the versions and times of previous are kept.
+// m is the manager which wrote secret.
+// createTime returns the time when the version of the reference was created, which is used for the propagation lag.
+func keyStatuses(spec *batchv1alpha1.BerglasSecretSpec, secret *v1.Secret, m secretManager, err error, previous []batchv1alpha1.KeyStatus, now metav1.Time, createTime func(ref, version string) (time.Time, bool)) []batchv1alpha1.KeyStatus {
+	errs := make(map[string]string)
+	for _, ke := range keyErrors(err) {
+		errs[ke.Key] = ke.Reason + ": " + ke.Err.Error()
+	}
+
+	refs := versionedValues(spec)
+	values := maps.Clone(refs)
+	for key := range errs {
+		values[key] = ""
+	}
+
+	last := make(map[string]batchv1alpha1.KeyStatus, len(previous))
+	for _, status := range previous {
+		last[status.Key] = status
+	}
+
+	versions := m.secretVersions(secret)
+	result := make([]batchv1alpha1.KeyStatus, 0, len(values))
+	for _, key := range slices.Sorted(maps.Keys(values)) {
+		status := batchv1alpha1.KeyStatus{
+			Key:                key,
+			Version:            last[key].Version,
+			LastResolvedTime:   last[key].LastResolvedTime,
+			VersionCreatedTime: last[key].VersionCreatedTime,
+			Reference:          last[key].Reference,
+			PropagationLag:     last[key].PropagationLag,
+			Error:              errs[key],
 		}
+		if secret != nil {
+			status.Version = versions[key]
+			if status.Version != last[key].Version {
+				setVersionCreatedTime(&status, last[key], refs[key], now, createTime)
+			}
+			if status.Error == "" {
+				status.LastResolvedTime = &now
+				if _, ok := secret.Data[key]; !ok && spec.DataOptions[key].Optional {
+					status.Message = "optional value is not found, the key is omitted"
+				}
+			}
+		}
+		result = append(result, status)
+	}
+	return result
+}
+
+// setVersionCreatedTime sets the creation time of the new version of ref to status.
+// The propagation lag is set only when ref is the same as the last one and the version is newer,
+// because the versions of other references, or older versions, may have been created long before.
+func setVersionCreatedTime(status *batchv1alpha1.KeyStatus, last batchv1alpha1.KeyStatus, ref string, now metav1.Time, createTime func(ref, version string) (time.Time, bool)) {
+	status.VersionCreatedTime, status.Reference, status.PropagationLag = nil, "", nil
+	if status.Version == "" {
+		return
+	}
+	t, ok := createTime(ref, status.Version)
+	if !ok {
+		return
+	}
+	status.VersionCreatedTime = &metav1.Time{Time: t}
+	status.Reference = ref
+	if last.Reference == ref && last.VersionCreatedTime != nil && t.After(last.VersionCreatedTime.Time) {
+		status.PropagationLag = &metav1.Duration{Duration: max(now.Sub(t), 0)}
+	}
+}
+
+// secretVersions returns the versions stored in the annotation of secret by m.
+func (m secretManager) secretVersions(secret *v1.Secret) map[string]string {
+	if secret == nil {
+		return nil
+	}
+	var versions map[string]string
+	if err := json.Unmarshal([]byte(secret.Annotations[m.versionKey]), &versions); err != nil {
+		return nil
+	}
+	return versions
+}
+
+// secretDataFromKeys returns the keys expanded from each DataFrom, which are stored in the annotation of secret by m.
+func (m secretManager) secretDataFromKeys(secret *v1.Secret) map[string][]string {
+	if secret == nil {
+		return nil
+	}
+	var keys map[string][]string
+	if err := json.Unmarshal([]byte(secret.Annotations[m.dataFromKeysKey]), &keys); err != nil {
+		return nil
 	}
-	return newConditions
+	return keys
 }
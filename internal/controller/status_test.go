@@ -1,53 +1,266 @@
 package controller
 
 import (
+	"errors"
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/kitagry/berglas-secret-controller/api/v1alpha1"
+	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
-func TestSetCondition(t *testing.T) {
+func TestSetReadyCondition(t *testing.T) {
 	tests := map[string]struct {
-		status       *v1alpha1.BerglasSecretStatus
-		newCondition v1alpha1.BerglasSecretCondition
-		expected     *v1alpha1.BerglasSecretStatus
+		status   *v1alpha1.BerglasSecretStatus
+		err      error
+		expected []metav1.Condition
 	}{
-		"test first condition": {
+		"set Ready condition when synced": {
+			status: &v1alpha1.BerglasSecretStatus{},
+			err:    nil,
+			expected: []metav1.Condition{
+				{Type: v1alpha1.ConditionTypeReady, Status: metav1.ConditionTrue, ObservedGeneration: 2, Reason: v1alpha1.ReasonSynced, Message: "Secret is synced"},
+			},
+		},
+		"set the reason of the error": {
+			status: &v1alpha1.BerglasSecretStatus{
+				Conditions: []metav1.Condition{
+					{Type: v1alpha1.ConditionTypeReady, Status: metav1.ConditionTrue, ObservedGeneration: 1, Reason: v1alpha1.ReasonSynced, Message: "Secret is synced"},
+				},
+			},
+			err: &keyError{Key: "password", Reason: reasonResolveFailed, Err: errors.New("not found")},
+			expected: []metav1.Condition{
+				{Type: v1alpha1.ConditionTypeReady, Status: metav1.ConditionFalse, ObservedGeneration: 2, Reason: reasonResolveFailed, Message: "password: not found"},
+			},
+		},
+		"set the reason of the secret error": {
+			status: &v1alpha1.BerglasSecretStatus{},
+			err:    fmt.Errorf("namespace default: %w", &secretError{Reason: reasonAdoptionRefused, Err: errors.New("secret is not managed")}),
+			expected: []metav1.Condition{
+				{Type: v1alpha1.ConditionTypeReady, Status: metav1.ConditionFalse, ObservedGeneration: 2, Reason: reasonAdoptionRefused, Message: "namespace default: secret is not managed"},
+			},
+		},
+		"remove legacy conditions": {
 			status: &v1alpha1.BerglasSecretStatus{
-				Conditions: nil,
+				Conditions: []metav1.Condition{
+					{Type: "Available", Status: metav1.ConditionTrue, Reason: "Success"},
+					{Type: "Failure", Status: metav1.ConditionFalse, Reason: "Error"},
+				},
 			},
-			newCondition: v1alpha1.BerglasSecretCondition{Type: v1alpha1.BerglasSecretAvailable, Status: metav1.ConditionTrue, Reason: "Success"},
-			expected: &v1alpha1.BerglasSecretStatus{
-				Conditions: []v1alpha1.BerglasSecretCondition{
-					{Type: v1alpha1.BerglasSecretAvailable, Status: metav1.ConditionTrue, Reason: "Success"},
+			err: nil,
+			expected: []metav1.Condition{
+				{Type: v1alpha1.ConditionTypeReady, Status: metav1.ConditionTrue, ObservedGeneration: 2, Reason: v1alpha1.ReasonSynced, Message: "Secret is synced"},
+			},
+		},
+	}
+
+	for n, tt := range tests {
+		t.Run(n, func(t *testing.T) {
+			setReadyCondition(tt.status, 2, tt.err)
+
+			if diff := cmp.Diff(tt.expected, tt.status.Conditions, cmpopts.IgnoreFields(metav1.Condition{}, "LastTransitionTime")); diff != "" {
+				t.Errorf("setReadyCondition result diff (-expect, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSetSuspendedCondition(t *testing.T) {
+	tests := map[string]struct {
+		status   *v1alpha1.BerglasSecretStatus
+		suspend  bool
+		expected []metav1.Condition
+	}{
+		"set Suspended condition when suspended": {
+			status: &v1alpha1.BerglasSecretStatus{
+				Conditions: []metav1.Condition{
+					{Type: v1alpha1.ConditionTypeReady, Status: metav1.ConditionTrue, ObservedGeneration: 1, Reason: v1alpha1.ReasonSynced, Message: "Secret is synced"},
 				},
 			},
+			suspend: true,
+			expected: []metav1.Condition{
+				{Type: v1alpha1.ConditionTypeReady, Status: metav1.ConditionTrue, ObservedGeneration: 1, Reason: v1alpha1.ReasonSynced, Message: "Secret is synced"},
+				{Type: v1alpha1.ConditionTypeSuspended, Status: metav1.ConditionTrue, ObservedGeneration: 2, Reason: v1alpha1.ReasonSuspended, Message: "Syncing is suspended by spec.suspend"},
+			},
 		},
-		"when success -> error -> success, delete 1st success condition": {
+		"remove Suspended condition when resumed": {
 			status: &v1alpha1.BerglasSecretStatus{
-				Conditions: []v1alpha1.BerglasSecretCondition{
-					{Type: v1alpha1.BerglasSecretAvailable, Status: metav1.ConditionTrue, Reason: "Success"},
-					{Type: v1alpha1.BerglasSecretFailure, Status: metav1.ConditionFalse, Reason: "Error"},
+				Conditions: []metav1.Condition{
+					{Type: v1alpha1.ConditionTypeReady, Status: metav1.ConditionTrue, ObservedGeneration: 1, Reason: v1alpha1.ReasonSynced, Message: "Secret is synced"},
+					{Type: v1alpha1.ConditionTypeSuspended, Status: metav1.ConditionTrue, ObservedGeneration: 1, Reason: v1alpha1.ReasonSuspended, Message: "Syncing is suspended by spec.suspend"},
 				},
 			},
-			newCondition: v1alpha1.BerglasSecretCondition{Type: v1alpha1.BerglasSecretAvailable, Status: metav1.ConditionTrue, Reason: "Success"},
-			expected: &v1alpha1.BerglasSecretStatus{
-				Conditions: []v1alpha1.BerglasSecretCondition{
-					{Type: v1alpha1.BerglasSecretFailure, Status: metav1.ConditionFalse, Reason: "Error"},
-					{Type: v1alpha1.BerglasSecretAvailable, Status: metav1.ConditionTrue, Reason: "Success"},
+			suspend: false,
+			expected: []metav1.Condition{
+				{Type: v1alpha1.ConditionTypeReady, Status: metav1.ConditionTrue, ObservedGeneration: 1, Reason: v1alpha1.ReasonSynced, Message: "Secret is synced"},
+			},
+		},
+		"remove legacy conditions when suspended": {
+			status: &v1alpha1.BerglasSecretStatus{
+				Conditions: []metav1.Condition{
+					{Type: "Available", Status: metav1.ConditionTrue, Reason: "Success"},
+					{Type: "Failure", Status: metav1.ConditionFalse, Reason: "Error"},
 				},
 			},
+			suspend: true,
+			expected: []metav1.Condition{
+				{Type: v1alpha1.ConditionTypeSuspended, Status: metav1.ConditionTrue, ObservedGeneration: 2, Reason: v1alpha1.ReasonSuspended, Message: "Syncing is suspended by spec.suspend"},
+			},
+		},
+	}
+
+	for n, tt := range tests {
+		t.Run(n, func(t *testing.T) {
+			setSuspendedCondition(tt.status, 2, tt.suspend)
+
+			if diff := cmp.Diff(tt.expected, tt.status.Conditions, cmpopts.IgnoreFields(metav1.Condition{}, "LastTransitionTime")); diff != "" {
+				t.Errorf("setSuspendedCondition result diff (-expect, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestKeyStatuses(t *testing.T) {
+	spec := &v1alpha1.BerglasSecretSpec{
+		Data: map[string]string{
+			"user":     "admin",
+			"password": "sm://project/password",
+			"flag":     "sm://project/flag",
+		},
+		DataOptions: map[string]v1alpha1.DataOption{
+			"flag": {Optional: true},
+		},
+		DataFrom: []v1alpha1.DataFrom{
+			{Ref: "sm://project/db"},
+		},
+	}
+
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{
+				secretVersionKey: `{"user":"","password":"1","flag":"","dataFrom/0":"3"}`,
+			},
+		},
+		Data: map[string][]byte{
+			"user":     []byte("admin"),
+			"password": []byte("p@ss"),
+		},
+	}
+
+	now := metav1.Now()
+	lastResolvedTime := metav1.NewTime(now.Add(-time.Hour))
+	previous := []v1alpha1.KeyStatus{
+		{Key: "dataFrom/0", Version: "2", LastResolvedTime: &lastResolvedTime},
+		{Key: "password", Version: "1", LastResolvedTime: &lastResolvedTime},
+	}
+	createdTime := metav1.NewTime(now.Add(-10 * time.Minute))
+	createTime := func(ref, version string) (time.Time, bool) {
+		if ref == "sm://project/db" && version == "3" {
+			return createdTime.Time, true
+		}
+		return time.Time{}, false
+	}
+
+	tests := map[string]struct {
+		secret   *v1.Secret
+		err      error
+		expected []v1alpha1.KeyStatus
+	}{
+		"no error": {
+			secret: secret,
+			err:    nil,
+			expected: []v1alpha1.KeyStatus{
+				{Key: "dataFrom/0", Version: "3", LastResolvedTime: &now, VersionCreatedTime: &createdTime, Reference: "sm://project/db"},
+				{Key: "flag", LastResolvedTime: &now, Message: "optional value is not found, the key is omitted"},
+				{Key: "password", Version: "1", LastResolvedTime: &now},
+				{Key: "user", LastResolvedTime: &now},
+			},
+		},
+		"report the error of each key": {
+			secret: secret,
+			err: fmt.Errorf("wrapped: %w", errors.Join(
+				&keyError{Key: "password", Reason: reasonResolveFailed, Err: errors.New("not found")},
+				&keyError{Key: "dataFrom/0", Reason: reasonParseFailed, Err: errors.New("invalid json")},
+			)),
+			expected: []v1alpha1.KeyStatus{
+				{Key: "dataFrom/0", Version: "3", LastResolvedTime: &lastResolvedTime, VersionCreatedTime: &createdTime, Reference: "sm://project/db", Error: "ParseFailed: invalid json"},
+				{Key: "flag", LastResolvedTime: &now, Message: "optional value is not found, the key is omitted"},
+				{Key: "password", Version: "1", LastResolvedTime: &lastResolvedTime, Error: "ResolveFailed: not found"},
+				{Key: "user", LastResolvedTime: &now},
+			},
+		},
+		"keep the previous versions when Secret isn't synced": {
+			secret: nil,
+			err:    &keyError{Key: "password", Reason: reasonResolveFailed, Err: errors.New("not found")},
+			expected: []v1alpha1.KeyStatus{
+				{Key: "dataFrom/0", Version: "2", LastResolvedTime: &lastResolvedTime},
+				{Key: "flag"},
+				{Key: "password", Version: "1", LastResolvedTime: &lastResolvedTime, Error: "ResolveFailed: not found"},
+				{Key: "user"},
+			},
+		},
+	}
+
+	for n, tt := range tests {
+		t.Run(n, func(t *testing.T) {
+			got := keyStatuses(spec, tt.secret, ownerManager, tt.err, previous, now, createTime)
+
+			if diff := cmp.Diff(tt.expected, got); diff != "" {
+				t.Errorf("keyStatuses result diff (-expect, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSetVersionCreatedTime(t *testing.T) {
+	now := metav1.Now()
+	oldCreatedTime := metav1.NewTime(now.Add(-24 * time.Hour))
+	createdTime := metav1.NewTime(now.Add(-10 * time.Minute))
+	createTime := func(ref, version string) (time.Time, bool) {
+		if version == "2" {
+			return createdTime.Time, true
+		}
+		return time.Time{}, false
+	}
+
+	tests := map[string]struct {
+		last     v1alpha1.KeyStatus
+		ref      string
+		expected v1alpha1.KeyStatus
+	}{
+		"same reference is rotated": {
+			last:     v1alpha1.KeyStatus{Key: "password", Version: "1", VersionCreatedTime: &oldCreatedTime, Reference: "sm://project/password"},
+			ref:      "sm://project/password",
+			expected: v1alpha1.KeyStatus{Key: "password", Version: "2", VersionCreatedTime: &createdTime, Reference: "sm://project/password", PropagationLag: &metav1.Duration{Duration: 10 * time.Minute}},
+		},
+		"reference is changed": {
+			last:     v1alpha1.KeyStatus{Key: "password", Version: "1", VersionCreatedTime: &oldCreatedTime, Reference: "sm://project/old-password"},
+			ref:      "sm://project/password",
+			expected: v1alpha1.KeyStatus{Key: "password", Version: "2", VersionCreatedTime: &createdTime, Reference: "sm://project/password"},
+		},
+		"older version": {
+			last:     v1alpha1.KeyStatus{Key: "password", Version: "3", VersionCreatedTime: &now, Reference: "sm://project/password"},
+			ref:      "sm://project/password",
+			expected: v1alpha1.KeyStatus{Key: "password", Version: "2", VersionCreatedTime: &createdTime, Reference: "sm://project/password"},
+		},
+		"unknown creation time": {
+			last:     v1alpha1.KeyStatus{Key: "password", Version: "1", VersionCreatedTime: &oldCreatedTime, Reference: "berglas://bucket/password"},
+			ref:      "berglas://bucket/password",
+			expected: v1alpha1.KeyStatus{Key: "password", Version: "4"},
 		},
 	}
 
 	for n, tt := range tests {
 		t.Run(n, func(t *testing.T) {
-			setCondition(tt.status, tt.newCondition)
+			got := v1alpha1.KeyStatus{Key: tt.last.Key, Version: tt.expected.Version, VersionCreatedTime: tt.last.VersionCreatedTime, Reference: tt.last.Reference}
+			setVersionCreatedTime(&got, tt.last, tt.ref, now, createTime)
 
-			if diff := cmp.Diff(tt.expected, tt.status); diff != "" {
-				t.Errorf("setCondition result diff (-expect, +got)\n%s", diff)
+			if diff := cmp.Diff(tt.expected, got); diff != "" {
+				t.Errorf("setVersionCreatedTime result diff (-expect, +got)\n%s", diff)
 			}
 		})
 	}
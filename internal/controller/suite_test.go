@@ -18,6 +18,7 @@ package controller
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"path/filepath"
 	"runtime"
@@ -27,7 +28,10 @@ import (
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes/scheme"
@@ -39,6 +43,8 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
 	batchv1alpha1 "github.com/kitagry/berglas-secret-controller/api/v1alpha1"
+	"github.com/kitagry/berglas-secret-controller/internal/kubernetes"
+	"github.com/kitagry/berglas-secret-controller/internal/provider"
 	// +kubebuilder:scaffold:imports
 )
 
@@ -105,12 +111,30 @@ var _ = BeforeSuite(func() {
 	})
 	Expect(err).ToNot(HaveOccurred())
 
-	berglasClient := &dummyBerglasClient{}
+	registry := provider.NewRegistry()
+	registry.Register("berglas", &dummyBerglasClient{})
+	registry.Register("sm", &dummyBerglasClient{})
+	kubernetes.Register(registry, k8sManager.GetClient(), k8sManager.GetAPIReader())
 	err = (&BerglasSecretReconciler{
 		Client:  k8sManager.GetClient(),
 		Log:     k8sManager.GetLogger(),
 		Scheme:  k8sManager.GetScheme(),
-		Berglas: berglasClient,
+		Berglas: registry,
+
+		Recorder:        k8sManager.GetEventRecorderFor("berglassecret-controller"),
+		ReloadWorkloads: true,
+	}).SetupWithManager(ctx, k8sManager)
+	Expect(err).ToNot(HaveOccurred())
+
+	err = (&ClusterBerglasSecretReconciler{
+		BerglasSecretReconciler: BerglasSecretReconciler{
+			Client:  k8sManager.GetClient(),
+			Log:     k8sManager.GetLogger(),
+			Scheme:  k8sManager.GetScheme(),
+			Berglas: registry,
+
+			Recorder: k8sManager.GetEventRecorderFor("clusterberglassecret-controller"),
+		},
 	}).SetupWithManager(ctx, k8sManager)
 	Expect(err).ToNot(HaveOccurred())
 
@@ -164,6 +188,9 @@ var _ = Describe("Create BerglasSecret", func() {
 				interval: interval,
 			})
 
+			createdSecret := &v1.Secret{}
+			Expect(k8sClient.Get(ctx, berglasSecretLookupKey, createdSecret)).Should(Succeed())
+
 			By("By updating berglasSecret")
 			createdBerglasSecret.Spec = batchv1alpha1.BerglasSecretSpec{
 				Data: map[string]string{
@@ -193,6 +220,8 @@ var _ = Describe("Create BerglasSecret", func() {
 				"test":  []uint8("resolved"),
 				"test3": []uint8("new secret"),
 			}))
+			// secret should be updated in place
+			Expect(updatedSecret.UID).Should(Equal(createdSecret.UID))
 
 			By("By deleting berglasSecret")
 			Expect(k8sClient.Delete(ctx, createdBerglasSecret)).Should(Succeed())
@@ -208,7 +237,7 @@ var _ = Describe("Create BerglasSecret", func() {
 	})
 
 	Context("When same name secret already exists", func() {
-		It("Should make berglasSecret status BerglasSecretFailure", func() {
+		It("Should make berglasSecret not ready", func() {
 			By("By creating a secret")
 			berglasSecretName := berglasSecretName + "-test2"
 			ctx := context.Background()
@@ -260,7 +289,11 @@ var _ = Describe("Create BerglasSecret", func() {
 				"test2": "unresolved",
 			}))
 
-			Expect(createdBerglasSecret.Status.Conditions[len(createdBerglasSecret.Status.Conditions)-1].Type).Should(Equal(batchv1alpha1.BerglasSecretFailure))
+			Eventually(func() bool {
+				err := k8sClient.Get(ctx, berglasSecretLookupKey, createdBerglasSecret)
+				return err == nil && meta.IsStatusConditionFalse(createdBerglasSecret.Status.Conditions, batchv1alpha1.ConditionTypeReady)
+			}, timeout, interval).Should(BeTrue())
+			Expect(createdBerglasSecret.Status.ObservedGeneration).Should(Equal(createdBerglasSecret.Generation))
 		})
 	})
 
@@ -319,6 +352,783 @@ var _ = Describe("Create BerglasSecret", func() {
 		})
 	})
 
+	Context("When resolved secret is not valid UTF-8", func() {
+		It("Should store the raw bytes in Secret", func() {
+			By("By creating a new BerglasSecret")
+			berglasSecretName := berglasSecretName + "-test5"
+			ctx := context.Background()
+			berglasSecretLookupKey := types.NamespacedName{Name: berglasSecretName, Namespace: berglasSecretNamespace}
+			payload := []byte{0x1f, 0x8b, 0x08, 0x00, 0xff, 0xfe, 0xc3, 0x28}
+			unlock := setBerglasFunc(func(ctx context.Context, s string) ([]byte, error) {
+				return payload, nil
+			}, func(ctx context.Context, s string) (string, error) {
+				return "version", nil
+			})
+			defer unlock()
+			createAndCheckBerglasSecret(ctx, CreateBerglasSecretParams{
+				NamespacedName: berglasSecretLookupKey,
+				BerglasData: map[string]string{
+					"keystore": "berglas://test/keystore",
+				},
+				ExpectSecretData: map[string][]uint8{
+					"keystore": payload,
+				},
+				timeout:  timeout,
+				interval: interval,
+			})
+		})
+	})
+
+	Context("When BerglasSecret references Secret in the cluster", func() {
+		It("Should copy the key and propagate the change", func() {
+			By("By creating a source secret")
+			berglasSecretName := berglasSecretName + "-test6"
+			ctx := context.Background()
+			source := &v1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      berglasSecretName + "-source",
+					Namespace: berglasSecretNamespace,
+					Annotations: map[string]string{
+						kubernetes.AllowedNamespacesAnnotation: berglasSecretNamespace,
+					},
+				},
+				Data: map[string][]byte{
+					"ca.crt": []byte("ca1"),
+				},
+			}
+			Expect(k8sClient.Create(ctx, source)).Should(Succeed())
+
+			By("By creating a berglasSecret")
+			berglasSecretLookupKey := types.NamespacedName{Name: berglasSecretName, Namespace: berglasSecretNamespace}
+			createAndCheckBerglasSecret(ctx, CreateBerglasSecretParams{
+				NamespacedName: berglasSecretLookupKey,
+				BerglasData: map[string]string{
+					"ca.crt": "k8s-secret://" + berglasSecretNamespace + "/" + source.Name + "/ca.crt",
+				},
+				ExpectSecretData: map[string][]uint8{
+					"ca.crt": []uint8("ca1"),
+				},
+				timeout:  timeout,
+				interval: interval,
+			})
+
+			By("By updating the source secret")
+			source.Data["ca.crt"] = []byte("ca2")
+			Expect(k8sClient.Update(ctx, source)).Should(Succeed())
+
+			Eventually(func() map[string][]byte {
+				updatedSecret := &v1.Secret{}
+				if err := k8sClient.Get(ctx, berglasSecretLookupKey, updatedSecret); err != nil {
+					return nil
+				}
+				return updatedSecret.Data
+			}, timeout, interval).Should(Equal(map[string][]byte{
+				"ca.crt": []byte("ca2"),
+			}))
+		})
+	})
+
+	Context("When BerglasSecret has template", func() {
+		It("Should render the template with resolved values", func() {
+			By("By creating a berglasSecret")
+			berglasSecretName := berglasSecretName + "-test7"
+			ctx := context.Background()
+			berglasSecretLookupKey := types.NamespacedName{Name: berglasSecretName, Namespace: berglasSecretNamespace}
+			unlock := setBerglasFunc(func(ctx context.Context, s string) ([]byte, error) {
+				return []byte("p@ss"), nil
+			}, func(ctx context.Context, s string) (string, error) {
+				return "version", nil
+			})
+			defer unlock()
+			berglasSecret := &batchv1alpha1.BerglasSecret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      berglasSecretName,
+					Namespace: berglasSecretNamespace,
+				},
+				Spec: batchv1alpha1.BerglasSecretSpec{
+					Data: map[string]string{
+						"user":     "admin",
+						"password": "sm://project/password",
+					},
+					Template: map[string]string{
+						"url": "postgres://{{ .user }}:{{ .password }}@localhost:5432/app",
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, berglasSecret)).Should(Succeed())
+
+			Eventually(func() map[string][]byte {
+				createdSecret := &v1.Secret{}
+				if err := k8sClient.Get(ctx, berglasSecretLookupKey, createdSecret); err != nil {
+					return nil
+				}
+				return createdSecret.Data
+			}, timeout, interval).Should(Equal(map[string][]byte{
+				"user":     []byte("admin"),
+				"password": []byte("p@ss"),
+				"url":      []byte("postgres://admin:p@ss@localhost:5432/app"),
+			}))
+		})
+	})
+
+	Context("When BerglasSecret has type", func() {
+		It("Should create typed Secret and recreate it when the type is changed", func() {
+			By("By creating a berglasSecret")
+			berglasSecretName := berglasSecretName + "-test8"
+			ctx := context.Background()
+			berglasSecretLookupKey := types.NamespacedName{Name: berglasSecretName, Namespace: berglasSecretNamespace}
+			unlock := setBerglasFunc(func(ctx context.Context, s string) ([]byte, error) {
+				return []byte("resolved"), nil
+			}, func(ctx context.Context, s string) (string, error) {
+				return "version", nil
+			})
+			defer unlock()
+			berglasSecret := &batchv1alpha1.BerglasSecret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      berglasSecretName,
+					Namespace: berglasSecretNamespace,
+				},
+				Spec: batchv1alpha1.BerglasSecretSpec{
+					Type: v1.SecretTypeTLS,
+					Data: map[string]string{
+						"tls.crt": "berglas://test/cert",
+						"tls.key": "berglas://test/key",
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, berglasSecret)).Should(Succeed())
+
+			createdSecret := &v1.Secret{}
+			Eventually(func() error {
+				return k8sClient.Get(ctx, berglasSecretLookupKey, createdSecret)
+			}, timeout, interval).Should(Succeed())
+			Expect(createdSecret.Type).Should(Equal(v1.SecretTypeTLS))
+
+			By("By changing the type")
+			Expect(k8sClient.Get(ctx, berglasSecretLookupKey, berglasSecret)).Should(Succeed())
+			berglasSecret.Spec.Type = v1.SecretTypeOpaque
+			Expect(k8sClient.Update(ctx, berglasSecret)).Should(Succeed())
+
+			Eventually(func() v1.SecretType {
+				updatedSecret := &v1.Secret{}
+				if err := k8sClient.Get(ctx, berglasSecretLookupKey, updatedSecret); err != nil {
+					return ""
+				}
+				return updatedSecret.Type
+			}, timeout, interval).Should(Equal(v1.SecretTypeOpaque))
+		})
+	})
+
+	Context("When BerglasSecret has target", func() {
+		It("Should create Secret with the target metadata and delete the old one when the name is changed", func() {
+			By("By creating a berglasSecret")
+			berglasSecretName := berglasSecretName + "-test9"
+			ctx := context.Background()
+			berglasSecretLookupKey := types.NamespacedName{Name: berglasSecretName, Namespace: berglasSecretNamespace}
+			berglasSecret := &batchv1alpha1.BerglasSecret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      berglasSecretName,
+					Namespace: berglasSecretNamespace,
+				},
+				Spec: batchv1alpha1.BerglasSecretSpec{
+					Data: map[string]string{
+						"key": "value",
+					},
+					Target: batchv1alpha1.SecretTarget{
+						Name:        berglasSecretName + "-first",
+						Labels:      map[string]string{"app": "test"},
+						Annotations: map[string]string{"example.com/owner": "team"},
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, berglasSecret)).Should(Succeed())
+
+			firstSecretLookupKey := types.NamespacedName{Name: berglasSecretName + "-first", Namespace: berglasSecretNamespace}
+			createdSecret := &v1.Secret{}
+			Eventually(func() error {
+				return k8sClient.Get(ctx, firstSecretLookupKey, createdSecret)
+			}, timeout, interval).Should(Succeed())
+			Expect(createdSecret.Labels).Should(HaveKeyWithValue("app", "test"))
+			Expect(createdSecret.Annotations).Should(HaveKeyWithValue("example.com/owner", "team"))
+
+			By("By changing the target name")
+			Expect(k8sClient.Get(ctx, berglasSecretLookupKey, berglasSecret)).Should(Succeed())
+			berglasSecret.Spec.Target.Name = berglasSecretName + "-second"
+			Expect(k8sClient.Update(ctx, berglasSecret)).Should(Succeed())
+
+			secondSecretLookupKey := types.NamespacedName{Name: berglasSecretName + "-second", Namespace: berglasSecretNamespace}
+			Eventually(func() error {
+				return k8sClient.Get(ctx, secondSecretLookupKey, &v1.Secret{})
+			}, timeout, interval).Should(Succeed())
+			Eventually(func() bool {
+				err := k8sClient.Get(ctx, firstSecretLookupKey, &v1.Secret{})
+				return k8serrors.IsNotFound(err)
+			}, timeout, interval).Should(BeTrue())
+		})
+	})
+
+	Context("When BerglasSecret has partialSync and one of the references fails", func() {
+		It("Should update the other keys and keep the last value of the failed key", func() {
+			By("By creating a berglasSecret")
+			berglasSecretName := berglasSecretName + "-partial"
+			ctx := context.Background()
+			berglasSecretLookupKey := types.NamespacedName{Name: berglasSecretName, Namespace: berglasSecretNamespace}
+			unlock := setBerglasFunc(func(ctx context.Context, s string) ([]byte, error) {
+				return []byte("resolved"), nil
+			}, func(ctx context.Context, s string) (string, error) {
+				return "version", nil
+			})
+			berglasSecret := &batchv1alpha1.BerglasSecret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      berglasSecretName,
+					Namespace: berglasSecretNamespace,
+				},
+				Spec: batchv1alpha1.BerglasSecretSpec{
+					Data: map[string]string{
+						"ok":     "berglas://test/ok",
+						"broken": "berglas://test/broken",
+					},
+					PartialSync:     true,
+					RefreshInterval: toPtr(metav1.Duration{Duration: time.Second * 1}),
+				},
+			}
+			Expect(k8sClient.Create(ctx, berglasSecret)).Should(Succeed())
+			secret := &v1.Secret{}
+			Eventually(func() bool {
+				err := k8sClient.Get(ctx, berglasSecretLookupKey, secret)
+				return err == nil
+			}, timeout, interval).Should(BeTrue())
+			unlock()
+
+			By("By breaking one of the references")
+			unlock = setBerglasFunc(func(ctx context.Context, s string) ([]byte, error) {
+				if s == "berglas://test/broken" {
+					return nil, errors.New("permission denied")
+				}
+				return []byte("resolved2"), nil
+			}, func(ctx context.Context, s string) (string, error) {
+				return "version2", nil
+			})
+			defer unlock()
+
+			Eventually(func() map[string][]byte {
+				if err := k8sClient.Get(ctx, berglasSecretLookupKey, secret); err != nil {
+					return nil
+				}
+				return secret.Data
+			}, timeout, interval).Should(Equal(map[string][]byte{
+				"ok":     []byte("resolved2"),
+				"broken": []byte("resolved"),
+			}))
+
+			updatedBerglasSecret := &batchv1alpha1.BerglasSecret{}
+			Eventually(func() bool {
+				if err := k8sClient.Get(ctx, berglasSecretLookupKey, updatedBerglasSecret); err != nil {
+					return false
+				}
+				for _, key := range updatedBerglasSecret.Status.Keys {
+					if key.Key == "broken" {
+						return key.Error != "" && key.Version == "version"
+					}
+				}
+				return false
+			}, timeout, interval).Should(BeTrue())
+			Expect(meta.IsStatusConditionFalse(updatedBerglasSecret.Status.Conditions, batchv1alpha1.ConditionTypeReady)).Should(BeTrue())
+		})
+	})
+
+	Context("When BerglasSecret has an optional reference which is not found", func() {
+		It("Should create Secret without the key", func() {
+			berglasSecretName := berglasSecretName + "-optional"
+			ctx := context.Background()
+			berglasSecretLookupKey := types.NamespacedName{Name: berglasSecretName, Namespace: berglasSecretNamespace}
+			unlock := setBerglasFunc(func(ctx context.Context, s string) ([]byte, error) {
+				if s == "berglas://test/flag" {
+					return nil, provider.NotFound(errors.New("secret does not exist"))
+				}
+				return []byte("resolved"), nil
+			}, func(ctx context.Context, s string) (string, error) {
+				if s == "berglas://test/flag" {
+					return "", provider.NotFound(errors.New("secret does not exist"))
+				}
+				return "version", nil
+			})
+			defer unlock()
+			berglasSecret := &batchv1alpha1.BerglasSecret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      berglasSecretName,
+					Namespace: berglasSecretNamespace,
+				},
+				Spec: batchv1alpha1.BerglasSecretSpec{
+					Data: map[string]string{
+						"test": "berglas://test/test",
+						"flag": "berglas://test/flag",
+					},
+					DataOptions: map[string]batchv1alpha1.DataOption{
+						"flag": {Optional: true},
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, berglasSecret)).Should(Succeed())
+
+			secret := &v1.Secret{}
+			Eventually(func() bool {
+				err := k8sClient.Get(ctx, berglasSecretLookupKey, secret)
+				return err == nil
+			}, timeout, interval).Should(BeTrue())
+			Expect(secret.Data).Should(Equal(map[string][]byte{
+				"test": []byte("resolved"),
+			}))
+
+			createdBerglasSecret := &batchv1alpha1.BerglasSecret{}
+			Eventually(func() bool {
+				err := k8sClient.Get(ctx, berglasSecretLookupKey, createdBerglasSecret)
+				return err == nil && meta.IsStatusConditionTrue(createdBerglasSecret.Status.Conditions, batchv1alpha1.ConditionTypeReady)
+			}, timeout, interval).Should(BeTrue())
+			Expect(createdBerglasSecret.Status.Keys).Should(ContainElement(HaveField("Message", Not(BeEmpty()))))
+		})
+	})
+
+	Context("When BerglasSecret is suspended", func() {
+		It("Should keep Secret until it is resumed", func() {
+			berglasSecretName := berglasSecretName + "-suspend"
+			ctx := context.Background()
+			berglasSecretLookupKey := types.NamespacedName{Name: berglasSecretName, Namespace: berglasSecretNamespace}
+			unlock := setBerglasFunc(func(ctx context.Context, s string) ([]byte, error) {
+				return []byte("resolved"), nil
+			}, func(ctx context.Context, s string) (string, error) {
+				return "version", nil
+			})
+			createdBerglasSecret := createAndCheckBerglasSecret(ctx, CreateBerglasSecretParams{
+				NamespacedName: berglasSecretLookupKey,
+				BerglasData: map[string]string{
+					"test": "berglas://test/test",
+				},
+				ExpectSecretData: map[string][]uint8{
+					"test": []uint8("resolved"),
+				},
+				timeout:  timeout,
+				interval: interval,
+			})
+			unlock()
+
+			By("By suspending berglasSecret")
+			createdBerglasSecret.Spec.Suspend = true
+			Expect(k8sClient.Update(ctx, createdBerglasSecret)).Should(Succeed())
+			Eventually(func() bool {
+				err := k8sClient.Get(ctx, berglasSecretLookupKey, createdBerglasSecret)
+				return err == nil && meta.IsStatusConditionTrue(createdBerglasSecret.Status.Conditions, batchv1alpha1.ConditionTypeSuspended)
+			}, timeout, interval).Should(BeTrue())
+
+			By("By changing the upstream secret")
+			unlock = setBerglasFunc(func(ctx context.Context, s string) ([]byte, error) {
+				return []byte("resolved2"), nil
+			}, func(ctx context.Context, s string) (string, error) {
+				return "version2", nil
+			})
+			defer unlock()
+			createdBerglasSecret.Spec.Data["test2"] = "literal"
+			Expect(k8sClient.Update(ctx, createdBerglasSecret)).Should(Succeed())
+			secret := &v1.Secret{}
+			Consistently(func() map[string][]byte {
+				if err := k8sClient.Get(ctx, berglasSecretLookupKey, secret); err != nil {
+					return nil
+				}
+				return secret.Data
+			}, time.Second*2, interval).Should(Equal(map[string][]byte{
+				"test": []byte("resolved"),
+			}))
+
+			By("By resuming berglasSecret")
+			Expect(k8sClient.Get(ctx, berglasSecretLookupKey, createdBerglasSecret)).Should(Succeed())
+			createdBerglasSecret.Spec.Suspend = false
+			Expect(k8sClient.Update(ctx, createdBerglasSecret)).Should(Succeed())
+			Eventually(func() map[string][]byte {
+				if err := k8sClient.Get(ctx, berglasSecretLookupKey, secret); err != nil {
+					return nil
+				}
+				return secret.Data
+			}, timeout, interval).Should(Equal(map[string][]byte{
+				"test":  []byte("resolved2"),
+				"test2": []byte("literal"),
+			}))
+		})
+	})
+
+	Context("When Secret already exists", func() {
+		It("Should adopt Secret only with target.adopt", func() {
+			berglasSecretName := berglasSecretName + "-adopt"
+			ctx := context.Background()
+			berglasSecretLookupKey := types.NamespacedName{Name: berglasSecretName, Namespace: berglasSecretNamespace}
+			existing := &v1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: berglasSecretName, Namespace: berglasSecretNamespace},
+				Data:       map[string][]byte{"existing": []byte("value")},
+			}
+			Expect(k8sClient.Create(ctx, existing)).Should(Succeed())
+
+			berglasSecret := &batchv1alpha1.BerglasSecret{
+				ObjectMeta: metav1.ObjectMeta{Name: berglasSecretName, Namespace: berglasSecretNamespace},
+				Spec: batchv1alpha1.BerglasSecretSpec{
+					Data: map[string]string{"test": "literal"},
+				},
+			}
+			Expect(k8sClient.Create(ctx, berglasSecret)).Should(Succeed())
+			Eventually(func() string {
+				if err := k8sClient.Get(ctx, berglasSecretLookupKey, berglasSecret); err != nil {
+					return ""
+				}
+				condition := meta.FindStatusCondition(berglasSecret.Status.Conditions, batchv1alpha1.ConditionTypeReady)
+				if condition == nil {
+					return ""
+				}
+				return condition.Reason
+			}, timeout, interval).Should(Equal(reasonAdoptionRefused))
+
+			By("By setting target.adopt")
+			berglasSecret.Spec.Target.Adopt = true
+			Expect(k8sClient.Update(ctx, berglasSecret)).Should(Succeed())
+			secret := &v1.Secret{}
+			Eventually(func() map[string][]byte {
+				if err := k8sClient.Get(ctx, berglasSecretLookupKey, secret); err != nil {
+					return nil
+				}
+				return secret.Data
+			}, timeout, interval).Should(Equal(map[string][]byte{
+				"test": []byte("literal"),
+			}))
+			Expect(metav1.IsControlledBy(secret, berglasSecret)).Should(BeTrue())
+		})
+
+		It("Should keep the other keys with creationPolicy Merge", func() {
+			berglasSecretName := berglasSecretName + "-merge"
+			ctx := context.Background()
+			berglasSecretLookupKey := types.NamespacedName{Name: berglasSecretName, Namespace: berglasSecretNamespace}
+			existing := &v1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: berglasSecretName, Namespace: berglasSecretNamespace},
+				Data:       map[string][]byte{"existing": []byte("value")},
+			}
+			Expect(k8sClient.Create(ctx, existing)).Should(Succeed())
+
+			berglasSecret := &batchv1alpha1.BerglasSecret{
+				ObjectMeta: metav1.ObjectMeta{Name: berglasSecretName, Namespace: berglasSecretNamespace},
+				Spec: batchv1alpha1.BerglasSecretSpec{
+					Data:   map[string]string{"test": "literal"},
+					Target: batchv1alpha1.SecretTarget{CreationPolicy: batchv1alpha1.CreationPolicyMerge},
+				},
+			}
+			Expect(k8sClient.Create(ctx, berglasSecret)).Should(Succeed())
+			secret := &v1.Secret{}
+			Eventually(func() map[string][]byte {
+				if err := k8sClient.Get(ctx, berglasSecretLookupKey, secret); err != nil {
+					return nil
+				}
+				return secret.Data
+			}, timeout, interval).Should(Equal(map[string][]byte{
+				"existing": []byte("value"),
+				"test":     []byte("literal"),
+			}))
+			Expect(metav1.GetControllerOf(secret)).Should(BeNil())
+		})
+
+		It("Should keep the keys of each BerglasSecret merged into the same Secret", func() {
+			secretName := berglasSecretName + "-merge-shared"
+			ctx := context.Background()
+			existing := &v1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: secretName, Namespace: berglasSecretNamespace},
+			}
+			Expect(k8sClient.Create(ctx, existing)).Should(Succeed())
+
+			for _, key := range []string{"first", "second"} {
+				Expect(k8sClient.Create(ctx, &batchv1alpha1.BerglasSecret{
+					ObjectMeta: metav1.ObjectMeta{Name: secretName + "-" + key, Namespace: berglasSecretNamespace},
+					Spec: batchv1alpha1.BerglasSecretSpec{
+						Data: map[string]string{key: "literal"},
+						Target: batchv1alpha1.SecretTarget{
+							Name:           secretName,
+							CreationPolicy: batchv1alpha1.CreationPolicyMerge,
+						},
+					},
+				})).Should(Succeed())
+			}
+			secret := &v1.Secret{}
+			getData := func() map[string][]byte {
+				if err := k8sClient.Get(ctx, types.NamespacedName{Name: secretName, Namespace: berglasSecretNamespace}, secret); err != nil {
+					return nil
+				}
+				return secret.Data
+			}
+			expected := map[string][]byte{
+				"first":  []byte("literal"),
+				"second": []byte("literal"),
+			}
+			Eventually(getData, timeout, interval).Should(Equal(expected))
+			Consistently(getData, time.Second, interval).Should(Equal(expected))
+		})
+	})
+
+	Context("When BerglasSecret with deletionPolicy Retain is deleted", func() {
+		It("Should keep Secret without the owner reference", func() {
+			berglasSecretName := berglasSecretName + "-retain"
+			ctx := context.Background()
+			berglasSecretLookupKey := types.NamespacedName{Name: berglasSecretName, Namespace: berglasSecretNamespace}
+			berglasSecret := &batchv1alpha1.BerglasSecret{
+				ObjectMeta: metav1.ObjectMeta{Name: berglasSecretName, Namespace: berglasSecretNamespace},
+				Spec: batchv1alpha1.BerglasSecretSpec{
+					Data:   map[string]string{"test": "literal"},
+					Target: batchv1alpha1.SecretTarget{DeletionPolicy: batchv1alpha1.DeletionPolicyRetain},
+				},
+			}
+			Expect(k8sClient.Create(ctx, berglasSecret)).Should(Succeed())
+			secret := &v1.Secret{}
+			Eventually(func() bool {
+				err := k8sClient.Get(ctx, berglasSecretLookupKey, secret)
+				return err == nil
+			}, timeout, interval).Should(BeTrue())
+			Eventually(func() []string {
+				if err := k8sClient.Get(ctx, berglasSecretLookupKey, berglasSecret); err != nil {
+					return nil
+				}
+				return berglasSecret.Finalizers
+			}, timeout, interval).Should(ContainElement(retainFinalizer))
+
+			By("By deleting berglasSecret")
+			Expect(k8sClient.Delete(ctx, berglasSecret)).Should(Succeed())
+			Eventually(func() bool {
+				err := k8sClient.Get(ctx, berglasSecretLookupKey, berglasSecret)
+				return k8serrors.IsNotFound(err)
+			}, timeout, interval).Should(BeTrue())
+			Expect(k8sClient.Get(ctx, berglasSecretLookupKey, secret)).Should(Succeed())
+			Expect(secret.OwnerReferences).Should(BeEmpty())
+			Expect(secret.Labels).Should(HaveKeyWithValue(batchv1alpha1.OrphanedLabel, "true"))
+			Expect(secret.Data).Should(Equal(map[string][]byte{"test": []byte("literal")}))
+		})
+	})
+
+	Context("When target is immutable", func() {
+		It("Should write a new Secret for each revision", func() {
+			berglasSecretName := berglasSecretName + "-immutable"
+			ctx := context.Background()
+			berglasSecretLookupKey := types.NamespacedName{Name: berglasSecretName, Namespace: berglasSecretNamespace}
+			unlock := setBerglasFunc(func(ctx context.Context, s string) ([]byte, error) {
+				return []byte("resolved"), nil
+			}, func(ctx context.Context, s string) (string, error) {
+				return "version", nil
+			})
+			berglasSecret := &batchv1alpha1.BerglasSecret{
+				ObjectMeta: metav1.ObjectMeta{Name: berglasSecretName, Namespace: berglasSecretNamespace},
+				Spec: batchv1alpha1.BerglasSecretSpec{
+					Data:            map[string]string{"test": "berglas://test/test"},
+					Target:          batchv1alpha1.SecretTarget{Immutable: true},
+					RefreshInterval: toPtr(metav1.Duration{Duration: time.Second * 1}),
+				},
+			}
+			Expect(k8sClient.Create(ctx, berglasSecret)).Should(Succeed())
+			Eventually(func() string {
+				if err := k8sClient.Get(ctx, berglasSecretLookupKey, berglasSecret); err != nil {
+					return ""
+				}
+				return berglasSecret.Status.SecretName
+			}, timeout, interval).Should(HavePrefix(berglasSecretName + "-"))
+			firstName := berglasSecret.Status.SecretName
+			unlock()
+
+			secret := &v1.Secret{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: firstName, Namespace: berglasSecretNamespace}, secret)).Should(Succeed())
+			Expect(secret.Immutable).Should(Equal(toPtr(true)))
+			Expect(secret.Data).Should(Equal(map[string][]byte{"test": []byte("resolved")}))
+
+			By("By changing the version of the upstream secret")
+			unlock = setBerglasFunc(func(ctx context.Context, s string) ([]byte, error) {
+				return []byte("resolved2"), nil
+			}, func(ctx context.Context, s string) (string, error) {
+				return "version2", nil
+			})
+			defer unlock()
+			Eventually(func() string {
+				if err := k8sClient.Get(ctx, berglasSecretLookupKey, berglasSecret); err != nil {
+					return ""
+				}
+				return berglasSecret.Status.SecretName
+			}, timeout, interval).ShouldNot(Equal(firstName))
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: berglasSecret.Status.SecretName, Namespace: berglasSecretNamespace}, secret)).Should(Succeed())
+			Expect(secret.Data).Should(Equal(map[string][]byte{"test": []byte("resolved2")}))
+
+			By("By keeping the old revision")
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: firstName, Namespace: berglasSecretNamespace}, secret)).Should(Succeed())
+			Expect(secret.Data).Should(Equal(map[string][]byte{"test": []byte("resolved")}))
+		})
+	})
+
+	Context("When Secret referenced by a workload with the reload annotation is changed", func() {
+		It("Should bump the checksum of the pod template", func() {
+			berglasSecretName := berglasSecretName + "-reload"
+			ctx := context.Background()
+			berglasSecretLookupKey := types.NamespacedName{Name: berglasSecretName, Namespace: berglasSecretNamespace}
+			labels := map[string]string{"app": berglasSecretName}
+			deployment := &appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        berglasSecretName,
+					Namespace:   berglasSecretNamespace,
+					Annotations: map[string]string{batchv1alpha1.ReloadAnnotation: "true"},
+				},
+				Spec: appsv1.DeploymentSpec{
+					Selector: &metav1.LabelSelector{MatchLabels: labels},
+					Template: v1.PodTemplateSpec{
+						ObjectMeta: metav1.ObjectMeta{Labels: labels},
+						Spec: v1.PodSpec{
+							Containers: []v1.Container{{
+								Name:  "app",
+								Image: "app",
+								EnvFrom: []v1.EnvFromSource{{
+									SecretRef: &v1.SecretEnvSource{LocalObjectReference: v1.LocalObjectReference{Name: berglasSecretName}},
+								}},
+							}},
+						},
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, deployment)).Should(Succeed())
+
+			unlock := setBerglasFunc(func(ctx context.Context, s string) ([]byte, error) {
+				return []byte("resolved"), nil
+			}, func(ctx context.Context, s string) (string, error) {
+				return "version", nil
+			})
+			createdBerglasSecret := createAndCheckBerglasSecret(ctx, CreateBerglasSecretParams{
+				NamespacedName: berglasSecretLookupKey,
+				BerglasData: map[string]string{
+					"test": "berglas://test/test",
+				},
+				ExpectSecretData: map[string][]uint8{
+					"test": []uint8("resolved"),
+				},
+				timeout:  timeout,
+				interval: interval,
+			})
+			unlock()
+			// The checksum is set on the next sync of the unchanged Secret.
+			Eventually(func() map[string]string {
+				if err := k8sClient.Get(ctx, berglasSecretLookupKey, deployment); err != nil {
+					return nil
+				}
+				return deployment.Spec.Template.Annotations
+			}, timeout, interval).Should(HaveKeyWithValue(checksumAnnotation(berglasSecretName), dataHash(map[string][]byte{"test": []byte("resolved")})))
+
+			By("By changing the version of the upstream secret")
+			unlock = setBerglasFunc(func(ctx context.Context, s string) ([]byte, error) {
+				return []byte("resolved2"), nil
+			}, func(ctx context.Context, s string) (string, error) {
+				return "version2", nil
+			})
+			defer unlock()
+			createdBerglasSecret.Spec.RefreshInterval = toPtr(metav1.Duration{Duration: time.Second * 1})
+			Expect(k8sClient.Update(ctx, createdBerglasSecret)).Should(Succeed())
+			Eventually(func() map[string]string {
+				if err := k8sClient.Get(ctx, berglasSecretLookupKey, deployment); err != nil {
+					return nil
+				}
+				return deployment.Spec.Template.Annotations
+			}, timeout, interval).Should(HaveKeyWithValue(checksumAnnotation(berglasSecretName), dataHash(map[string][]byte{"test": []byte("resolved2")})))
+		})
+	})
+
+	Context("When BerglasSecret is synced", func() {
+		It("Should record the events on BerglasSecret and Secret", func() {
+			berglasSecretName := berglasSecretName + "-events"
+			ctx := context.Background()
+			berglasSecretLookupKey := types.NamespacedName{Name: berglasSecretName, Namespace: berglasSecretNamespace}
+			createdBerglasSecret := createAndCheckBerglasSecret(ctx, CreateBerglasSecretParams{
+				NamespacedName: berglasSecretLookupKey,
+				BerglasData: map[string]string{
+					"test": "literal",
+				},
+				ExpectSecretData: map[string][]uint8{
+					"test": []uint8("literal"),
+				},
+				timeout:  timeout,
+				interval: interval,
+			})
+
+			eventReasons := func(kind string) func() []string {
+				return func() []string {
+					var events v1.EventList
+					err := k8sClient.List(ctx, &events, client.InNamespace(berglasSecretNamespace))
+					if err != nil {
+						return nil
+					}
+					var reasons []string
+					for _, event := range events.Items {
+						if event.InvolvedObject.Kind == kind && event.InvolvedObject.Name == berglasSecretName {
+							reasons = append(reasons, event.Reason)
+						}
+					}
+					return reasons
+				}
+			}
+			Eventually(eventReasons("BerglasSecret"), timeout, interval).Should(ContainElement(eventReasonCreated))
+			Eventually(eventReasons("Secret"), timeout, interval).Should(ContainElement(eventReasonCreated))
+
+			By("By changing the data")
+			createdBerglasSecret.Spec.Data["test"] = "literal2"
+			Expect(k8sClient.Update(ctx, createdBerglasSecret)).Should(Succeed())
+			Eventually(eventReasons("BerglasSecret"), timeout, interval).Should(ContainElement(eventReasonUpdated))
+		})
+	})
+
+	Context("When force-sync annotation is changed", func() {
+		It("Should sync Secret even if the version is not changed", func() {
+			berglasSecretName := berglasSecretName + "-force-sync"
+			ctx := context.Background()
+			berglasSecretLookupKey := types.NamespacedName{Name: berglasSecretName, Namespace: berglasSecretNamespace}
+			unlock := setBerglasFunc(func(ctx context.Context, s string) ([]byte, error) {
+				return []byte("resolved"), nil
+			}, func(ctx context.Context, s string) (string, error) {
+				return "version", nil
+			})
+			createdBerglasSecret := createAndCheckBerglasSecret(ctx, CreateBerglasSecretParams{
+				NamespacedName: berglasSecretLookupKey,
+				BerglasData: map[string]string{
+					"test": "berglas://test/test",
+				},
+				ExpectSecretData: map[string][]uint8{
+					"test": []uint8("resolved"),
+				},
+				timeout:  timeout,
+				interval: interval,
+			})
+			unlock()
+
+			By("By changing the upstream secret without changing the version")
+			unlock = setBerglasFunc(func(ctx context.Context, s string) ([]byte, error) {
+				return []byte("resolved2"), nil
+			}, func(ctx context.Context, s string) (string, error) {
+				return "version", nil
+			})
+			defer unlock()
+			if createdBerglasSecret.Annotations == nil {
+				createdBerglasSecret.Annotations = map[string]string{}
+			}
+			createdBerglasSecret.Annotations[batchv1alpha1.ForceSyncAnnotation] = "1"
+			Expect(k8sClient.Update(ctx, createdBerglasSecret)).Should(Succeed())
+
+			secret := &v1.Secret{}
+			Eventually(func() map[string][]byte {
+				if err := k8sClient.Get(ctx, berglasSecretLookupKey, secret); err != nil {
+					return nil
+				}
+				return secret.Data
+			}, timeout, interval).Should(Equal(map[string][]byte{
+				"test": []byte("resolved2"),
+			}))
+			Eventually(func() string {
+				if err := k8sClient.Get(ctx, berglasSecretLookupKey, createdBerglasSecret); err != nil {
+					return ""
+				}
+				return createdBerglasSecret.Status.LastForceSyncToken
+			}, timeout, interval).Should(Equal("1"))
+		})
+	})
+
 	Context("When secret will be changed", func() {
 		It("Should refresh BerglasSecret after IntervalRefresh", func() {
 			By("By creating a berglasSecret")
@@ -371,6 +1181,90 @@ var _ = Describe("Create BerglasSecret", func() {
 	})
 })
 
+var _ = Describe("Create ClusterBerglasSecret", func() {
+	const (
+		clusterBerglasSecretName = "cluster-berglas-secret"
+
+		timeout  = time.Second * 10
+		interval = time.Millisecond * 10
+	)
+
+	Context("When ClusterBerglasSecret selects namespaces", func() {
+		It("Should write Secret into the matching namespaces and delete it from the namespaces which stop matching", func() {
+			ctx := context.Background()
+			By("By creating namespaces")
+			for _, name := range []string{"cluster-test1", "cluster-test2"} {
+				ns := &v1.Namespace{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:   name,
+						Labels: map[string]string{"team": "backend"},
+					},
+				}
+				Expect(k8sClient.Create(ctx, ns)).Should(Succeed())
+			}
+
+			By("By creating a clusterBerglasSecret")
+			clusterBerglasSecret := &batchv1alpha1.ClusterBerglasSecret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: clusterBerglasSecretName,
+				},
+				Spec: batchv1alpha1.ClusterBerglasSecretSpec{
+					BerglasSecretSpec: batchv1alpha1.BerglasSecretSpec{
+						Data: map[string]string{
+							"api-key": "value",
+						},
+					},
+					NamespaceSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"team": "backend"},
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, clusterBerglasSecret)).Should(Succeed())
+
+			for _, name := range []string{"cluster-test1", "cluster-test2"} {
+				Eventually(func() map[string][]byte {
+					secret := &v1.Secret{}
+					if err := k8sClient.Get(ctx, types.NamespacedName{Name: clusterBerglasSecretName, Namespace: name}, secret); err != nil {
+						return nil
+					}
+					return secret.Data
+				}, timeout, interval).Should(Equal(map[string][]byte{"api-key": []byte("value")}))
+			}
+
+			By("By removing the label from the namespace")
+			ns := &v1.Namespace{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "cluster-test2"}, ns)).Should(Succeed())
+			ns.Labels = nil
+			Expect(k8sClient.Update(ctx, ns)).Should(Succeed())
+
+			Eventually(func() bool {
+				err := k8sClient.Get(ctx, types.NamespacedName{Name: clusterBerglasSecretName, Namespace: "cluster-test2"}, &v1.Secret{})
+				return k8serrors.IsNotFound(err)
+			}, timeout, interval).Should(BeTrue())
+
+			By("By creating a new matching namespace")
+			Expect(k8sClient.Create(ctx, &v1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   "cluster-test3",
+					Labels: map[string]string{"team": "backend"},
+				},
+			})).Should(Succeed())
+
+			Eventually(func() error {
+				return k8sClient.Get(ctx, types.NamespacedName{Name: clusterBerglasSecretName, Namespace: "cluster-test3"}, &v1.Secret{})
+			}, timeout, interval).Should(Succeed())
+
+			Eventually(func() []string {
+				created := &batchv1alpha1.ClusterBerglasSecret{}
+				if err := k8sClient.Get(ctx, types.NamespacedName{Name: clusterBerglasSecretName}, created); err != nil {
+					return nil
+				}
+				return created.Status.Namespaces
+			}, timeout, interval).Should(Equal([]string{"cluster-test1", "cluster-test3"}))
+		})
+	})
+})
+
 type CreateBerglasSecretParams struct {
 	NamespacedName    types.NamespacedName
 	BerglasData       map[string]string
@@ -430,6 +1324,10 @@ func (*dummyBerglasClient) Version(ctx context.Context, s string) (string, error
 	return versionFunc(ctx, s)
 }
 
+func (*dummyBerglasClient) Validate(s string) error {
+	return nil
+}
+
 func setBerglasFunc(rFunc func(context.Context, string) ([]byte, error), pFunc func(context.Context, string) (string, error)) func() {
 	mux.Lock()
 	resolveFunc = rFunc
@@ -0,0 +1,268 @@
+// Package dataformat expands structured secret payloads, such as JSON, YAML and dotenv, into Secret keys,
+// and extracts a field from them.
+package dataformat
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"k8s.io/client-go/util/jsonpath"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	JSON   = "json"
+	YAML   = "yaml"
+	Dotenv = "dotenv"
+)
+
+// Expand parses data as format and returns its top-level fields.
+// String values are returned as is, and the other values are encoded as JSON.
+func Expand(format string, data []byte) (map[string][]byte, error) {
+	switch format {
+	case JSON, "":
+		return expandJSON(data)
+	case YAML:
+		b, err := yaml.YAMLToJSON(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse yaml: %w", err)
+		}
+		return expandJSON(b)
+	case Dotenv:
+		return expandDotenv(data)
+	default:
+		return nil, fmt.Errorf("unsupported format %q", format)
+	}
+}
+
+func expandJSON(data []byte) (map[string][]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("failed to parse json object: %w", err)
+	}
+
+	result := make(map[string][]byte, len(fields))
+	for key, raw := range fields {
+		var s string
+		if err := json.Unmarshal(raw, &s); err == nil {
+			result[key] = []byte(s)
+			continue
+		}
+		result[key] = bytes.TrimSpace(raw)
+	}
+	return result, nil
+}
+
+func expandDotenv(data []byte) (map[string][]byte, error) {
+	result := make(map[string][]byte)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("failed to parse dotenv: line %d is not KEY=VALUE", lineNum)
+		}
+
+		value, err := dotenvValue(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse dotenv: line %d: %w", lineNum, err)
+		}
+		result[key] = []byte(value)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to parse dotenv: %w", err)
+	}
+	return result, nil
+}
+
+func dotenvValue(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, `"`):
+		end := strings.LastIndex(value, `"`)
+		if end == 0 {
+			return "", fmt.Errorf("unterminated quoted value")
+		}
+		return strconv.Unquote(value[:end+1])
+	case strings.HasPrefix(value, "'"):
+		end := strings.LastIndex(value, "'")
+		if end == 0 {
+			return "", fmt.Errorf("unterminated quoted value")
+		}
+		return value[1:end], nil
+	default:
+		// unquoted values can have a trailing comment
+		if i := strings.Index(value, " #"); i >= 0 {
+			value = strings.TrimSpace(value[:i])
+		}
+		return value, nil
+	}
+}
+
+// Filter returns the fields whose key matches one of include and none of exclude, with prefix added to the key.
+// include and exclude are glob patterns of path.Match. Empty include matches all keys.
+func Filter(fields map[string][]byte, prefix string, include, exclude []string) (map[string][]byte, error) {
+	result := make(map[string][]byte, len(fields))
+	for key, value := range fields {
+		included := len(include) == 0
+		for _, pattern := range include {
+			ok, err := path.Match(pattern, key)
+			if err != nil {
+				return nil, fmt.Errorf("invalid include pattern %q: %w", pattern, err)
+			}
+			if ok {
+				included = true
+				break
+			}
+		}
+		if !included {
+			continue
+		}
+
+		excluded := false
+		for _, pattern := range exclude {
+			ok, err := path.Match(pattern, key)
+			if err != nil {
+				return nil, fmt.Errorf("invalid exclude pattern %q: %w", pattern, err)
+			}
+			if ok {
+				excluded = true
+				break
+			}
+		}
+		if excluded {
+			continue
+		}
+
+		result[prefix+key] = value
+	}
+	return result, nil
+}
+
+// Extract returns the field of data selected by jsonPath or path. data is returned as is when both are empty.
+func Extract(data []byte, jsonPath, path string) ([]byte, error) {
+	switch {
+	case jsonPath != "":
+		return ExtractJSONPath(data, jsonPath)
+	case path != "":
+		return ExtractPath(data, path)
+	default:
+		return data, nil
+	}
+}
+
+// ExtractJSONPath parses data as JSON or YAML and returns the field selected by the JSONPath expression, e.g. {.password}.
+// The braces can be omitted. The expression must select exactly one value.
+func ExtractJSONPath(data []byte, expr string) ([]byte, error) {
+	obj, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.Contains(expr, "{") {
+		expr = "{" + expr + "}"
+	}
+	j := jsonpath.New("extract")
+	if err := j.Parse(expr); err != nil {
+		return nil, fmt.Errorf("invalid jsonPath %q: %w", expr, err)
+	}
+	results, err := j.FindResults(obj)
+	if err != nil {
+		return nil, err
+	}
+	if len(results) != 1 || len(results[0]) != 1 {
+		return nil, fmt.Errorf("jsonPath %q must select exactly one value", expr)
+	}
+	return fieldValue(results[0][0].Interface())
+}
+
+// ExtractPath parses data as JSON or YAML and returns the field selected by the gjson style path, e.g. users.0.password.
+// Dots in a key are escaped with a backslash.
+func ExtractPath(data []byte, p string) ([]byte, error) {
+	obj, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, segment := range splitPath(p) {
+		switch v := obj.(type) {
+		case map[string]any:
+			value, ok := v[segment]
+			if !ok {
+				return nil, fmt.Errorf("path %q is not found", p)
+			}
+			obj = value
+		case []any:
+			i, err := strconv.Atoi(segment)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, fmt.Errorf("path %q is not found", p)
+			}
+			obj = v[i]
+		default:
+			return nil, fmt.Errorf("path %q is not found", p)
+		}
+	}
+	return fieldValue(obj)
+}
+
+// ValidateJSONPath checks that expr is a valid JSONPath expression.
+func ValidateJSONPath(expr string) error {
+	if !strings.Contains(expr, "{") {
+		expr = "{" + expr + "}"
+	}
+	if err := jsonpath.New("validate").Parse(expr); err != nil {
+		return fmt.Errorf("invalid jsonPath %q: %w", expr, err)
+	}
+	return nil
+}
+
+func splitPath(p string) []string {
+	var segments []string
+	var current strings.Builder
+	for i := 0; i < len(p); i++ {
+		switch {
+		case p[i] == '\\' && i+1 < len(p):
+			i++
+			current.WriteByte(p[i])
+		case p[i] == '.':
+			segments = append(segments, current.String())
+			current.Reset()
+		default:
+			current.WriteByte(p[i])
+		}
+	}
+	return append(segments, current.String())
+}
+
+// decode parses data as JSON or YAML. YAML is a superset of JSON, so both are converted by YAMLToJSON.
+func decode(data []byte) (any, error) {
+	b, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse payload: %w", err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	var obj any
+	if err := decoder.Decode(&obj); err != nil {
+		return nil, fmt.Errorf("failed to parse payload: %w", err)
+	}
+	return obj, nil
+}
+
+// fieldValue returns v as is when it is a string, and as JSON otherwise.
+func fieldValue(v any) ([]byte, error) {
+	if s, ok := v.(string); ok {
+		return []byte(s), nil
+	}
+	return json.Marshal(v)
+}
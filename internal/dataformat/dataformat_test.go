@@ -0,0 +1,198 @@
+package dataformat
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestExpand(t *testing.T) {
+	tests := map[string]struct {
+		format        string
+		data          string
+		expected      map[string][]byte
+		expectedError bool
+	}{
+		"expand json": {
+			format: JSON,
+			data:   `{"user":"admin","port":5432,"options":{"ssl":true}}`,
+			expected: map[string][]byte{
+				"user":    []byte("admin"),
+				"port":    []byte("5432"),
+				"options": []byte(`{"ssl":true}`),
+			},
+		},
+		"expand yaml": {
+			format: YAML,
+			data:   "user: admin\npassword: p@ss\n",
+			expected: map[string][]byte{
+				"user":     []byte("admin"),
+				"password": []byte("p@ss"),
+			},
+		},
+		"expand dotenv": {
+			format: Dotenv,
+			data:   "# comment\nexport USER=admin\nPASSWORD=\"p@ss\\nword\"\nHOST='localhost' \nPORT=5432 # comment\n",
+			expected: map[string][]byte{
+				"USER":     []byte("admin"),
+				"PASSWORD": []byte("p@ss\nword"),
+				"HOST":     []byte("localhost"),
+				"PORT":     []byte("5432"),
+			},
+		},
+		"return error when json is not an object": {
+			format:        JSON,
+			data:          `["admin"]`,
+			expectedError: true,
+		},
+		"return error when dotenv line has no value": {
+			format:        Dotenv,
+			data:          "USER\n",
+			expectedError: true,
+		},
+		"return error when format is unsupported": {
+			format:        "toml",
+			data:          `user = "admin"`,
+			expectedError: true,
+		},
+	}
+
+	for n, tt := range tests {
+		t.Run(n, func(t *testing.T) {
+			got, err := Expand(tt.format, []byte(tt.data))
+			if (err != nil) != tt.expectedError {
+				t.Fatalf("expected error %v, but got %v", tt.expectedError, err)
+			}
+
+			if diff := cmp.Diff(tt.expected, got); diff != "" {
+				t.Errorf("Expand result diff (-expect, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestFilter(t *testing.T) {
+	fields := map[string][]byte{
+		"user":          []byte("admin"),
+		"password":      []byte("p@ss"),
+		"password_hash": []byte("hash"),
+	}
+
+	tests := map[string]struct {
+		prefix        string
+		include       []string
+		exclude       []string
+		expected      map[string][]byte
+		expectedError bool
+	}{
+		"add prefix to all keys": {
+			prefix: "db_",
+			expected: map[string][]byte{
+				"db_user":          []byte("admin"),
+				"db_password":      []byte("p@ss"),
+				"db_password_hash": []byte("hash"),
+			},
+		},
+		"include and exclude keys": {
+			include: []string{"pass*"},
+			exclude: []string{"*_hash"},
+			expected: map[string][]byte{
+				"password": []byte("p@ss"),
+			},
+		},
+		"return error when pattern is malformed": {
+			include:       []string{"["},
+			expectedError: true,
+		},
+	}
+
+	for n, tt := range tests {
+		t.Run(n, func(t *testing.T) {
+			got, err := Filter(fields, tt.prefix, tt.include, tt.exclude)
+			if (err != nil) != tt.expectedError {
+				t.Fatalf("expected error %v, but got %v", tt.expectedError, err)
+			}
+
+			if diff := cmp.Diff(tt.expected, got); diff != "" {
+				t.Errorf("Filter result diff (-expect, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestExtract(t *testing.T) {
+	payload := []byte(`{"user":"admin","password":"p@ss","port":5432,"hosts":[{"name":"primary"}],"tls.crt":"cert"}`)
+
+	tests := map[string]struct {
+		data          []byte
+		jsonPath      string
+		path          string
+		expected      []byte
+		expectedError bool
+	}{
+		"extract a field with jsonPath": {
+			data:     payload,
+			jsonPath: "{.password}",
+			expected: []byte("p@ss"),
+		},
+		"extract a field with jsonPath without braces": {
+			data:     payload,
+			jsonPath: ".hosts[0].name",
+			expected: []byte("primary"),
+		},
+		"extract a number with path": {
+			data:     payload,
+			path:     "port",
+			expected: []byte("5432"),
+		},
+		"extract an array element with path": {
+			data:     payload,
+			path:     "hosts.0",
+			expected: []byte(`{"name":"primary"}`),
+		},
+		"extract an escaped key with path": {
+			data:     payload,
+			path:     `tls\.crt`,
+			expected: []byte("cert"),
+		},
+		"extract a field from yaml": {
+			data:     []byte("db:\n  password: p@ss\n"),
+			path:     "db.password",
+			expected: []byte("p@ss"),
+		},
+		"return error when jsonPath is not found": {
+			data:          payload,
+			jsonPath:      "{.unknown}",
+			expectedError: true,
+		},
+		"return error when path is not found": {
+			data:          payload,
+			path:          "hosts.1.name",
+			expectedError: true,
+		},
+		"return error when payload doesn't parse": {
+			data:          []byte("{"),
+			path:          "user",
+			expectedError: true,
+		},
+	}
+
+	for n, tt := range tests {
+		t.Run(n, func(t *testing.T) {
+			var got []byte
+			var err error
+			if tt.jsonPath != "" {
+				got, err = ExtractJSONPath(tt.data, tt.jsonPath)
+			} else {
+				got, err = ExtractPath(tt.data, tt.path)
+			}
+			if (err != nil) != tt.expectedError {
+				t.Fatalf("expected error %v, but got %v", tt.expectedError, err)
+			}
+
+			if diff := cmp.Diff(tt.expected, got); diff != "" {
+				t.Errorf("extract result diff (-expect, +got)\n%s", diff)
+			}
+		})
+	}
+}
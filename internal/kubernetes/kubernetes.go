@@ -0,0 +1,172 @@
+// Package kubernetes provides the providers which copy keys from Secrets and ConfigMaps in the cluster.
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/kitagry/berglas-secret-controller/internal/provider"
+)
+
+const (
+	// SchemeSecret is the scheme of k8s-secret://<namespace>/<name>/<key> references.
+	SchemeSecret = "k8s-secret"
+	// SchemeConfigMap is the scheme of k8s-configmap://<namespace>/<name>/<key> references.
+	SchemeConfigMap = "k8s-configmap"
+
+	// AllowedNamespacesAnnotation is the annotation which source objects must have to be referenced.
+	// The value is a comma separated list of namespaces which are allowed to reference the object, or "*" for all namespaces.
+	AllowedNamespacesAnnotation = "kitagry.github.io/berglasSecretAllowedNamespaces"
+)
+
+// Reference is a parsed k8s-secret:// or k8s-configmap:// reference.
+type Reference struct {
+	Scheme    string
+	Namespace string
+	Name      string
+	Key       string
+}
+
+// ParseReference parses <scheme>://<namespace>/<name>/<key>.
+func ParseReference(s string) (*Reference, error) {
+	scheme, rest, ok := strings.Cut(s, "://")
+	if !ok || (scheme != SchemeSecret && scheme != SchemeConfigMap) {
+		return nil, fmt.Errorf("reference %s doesn't start with %s:// or %s://", s, SchemeSecret, SchemeConfigMap)
+	}
+
+	parts := strings.Split(rest, "/")
+	if len(parts) != 3 || slices.Contains(parts, "") {
+		return nil, fmt.Errorf("reference %s should be %s://<namespace>/<name>/<key>", s, scheme)
+	}
+	return &Reference{Scheme: scheme, Namespace: parts[0], Name: parts[1], Key: parts[2]}, nil
+}
+
+// SourceKey returns the key which identifies the referenced object.
+func (r *Reference) SourceKey() string {
+	return SourceKey(r.Scheme, types.NamespacedName{Namespace: r.Namespace, Name: r.Name})
+}
+
+// SourceKey returns the key which identifies the object of the scheme.
+func SourceKey(scheme string, name types.NamespacedName) string {
+	return scheme + "/" + name.String()
+}
+
+// Provider resolves references to keys of Secrets or ConfigMaps.
+// The version of a reference is the resourceVersion of the object.
+// The data of ConfigMaps is read with apiReader, because only the metadata of ConfigMaps is cached.
+type Provider struct {
+	reader    client.Reader
+	apiReader client.Reader
+	scheme    string
+}
+
+var _ provider.Provider = &Provider{}
+
+// Register registers the Secret and ConfigMap providers to r.
+// reader is the cached client, and apiReader reads from the API server.
+func Register(r *provider.Registry, reader, apiReader client.Reader) {
+	r.Register(SchemeSecret, &Provider{reader: reader, apiReader: apiReader, scheme: SchemeSecret})
+	r.Register(SchemeConfigMap, &Provider{reader: reader, apiReader: apiReader, scheme: SchemeConfigMap})
+}
+
+func (p *Provider) Resolve(ctx context.Context, ref string) ([]byte, error) {
+	r, obj, err := p.get(ctx, ref, true)
+	if err != nil {
+		return nil, err
+	}
+
+	switch obj := obj.(type) {
+	case *v1.Secret:
+		if value, ok := obj.Data[r.Key]; ok {
+			return value, nil
+		}
+	case *v1.ConfigMap:
+		if value, ok := obj.Data[r.Key]; ok {
+			return []byte(value), nil
+		}
+		if value, ok := obj.BinaryData[r.Key]; ok {
+			return value, nil
+		}
+	}
+	return nil, provider.NotFound(fmt.Errorf("key %q is not found in %s", r.Key, r.SourceKey()))
+}
+
+func (p *Provider) Version(ctx context.Context, ref string) (string, error) {
+	_, obj, err := p.get(ctx, ref, false)
+	if err != nil {
+		return "", err
+	}
+	return obj.GetResourceVersion(), nil
+}
+
+func (p *Provider) Validate(ref string) error {
+	_, err := p.parse(ref)
+	return err
+}
+
+func (p *Provider) parse(ref string) (*Reference, error) {
+	r, err := ParseReference(ref)
+	if err != nil {
+		return nil, err
+	}
+	if r.Scheme != p.scheme {
+		return nil, fmt.Errorf("reference %s doesn't start with %s://", ref, p.scheme)
+	}
+	return r, nil
+}
+
+// get returns the object of ref. When data is false, only the metadata of ConfigMaps is read from the cache.
+func (p *Provider) get(ctx context.Context, ref string, data bool) (*Reference, client.Object, error) {
+	r, err := p.parse(ref)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	reader := p.reader
+	var obj client.Object = &v1.Secret{}
+	if r.Scheme == SchemeConfigMap {
+		if data {
+			reader, obj = p.apiReader, &v1.ConfigMap{}
+		} else {
+			metadata := &metav1.PartialObjectMetadata{}
+			metadata.SetGroupVersionKind(v1.SchemeGroupVersion.WithKind("ConfigMap"))
+			obj = metadata
+		}
+	}
+	if err := reader.Get(ctx, types.NamespacedName{Namespace: r.Namespace, Name: r.Name}, obj); err != nil {
+		if apierrors.IsNotFound(err) {
+			err = provider.NotFound(err)
+		}
+		return nil, nil, fmt.Errorf("failed to get %s: %w", r.SourceKey(), err)
+	}
+
+	namespace, _ := provider.NamespaceFrom(ctx)
+	if !isAllowed(obj, namespace) {
+		return nil, nil, fmt.Errorf("%s doesn't allow access from namespace %q, add %s annotation to it", r.SourceKey(), namespace, AllowedNamespacesAnnotation)
+	}
+	return r, obj, nil
+}
+
+// isAllowed reports whether obj can be referenced from namespace.
+// An empty namespace means the reference is from a cluster scoped object, and only "*" allows it.
+func isAllowed(obj client.Object, namespace string) bool {
+	value, ok := obj.GetAnnotations()[AllowedNamespacesAnnotation]
+	if !ok {
+		return false
+	}
+	for _, allowed := range strings.Split(value, ",") {
+		allowed = strings.TrimSpace(allowed)
+		if allowed == "*" || (namespace != "" && allowed == namespace) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,184 @@
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/kitagry/berglas-secret-controller/internal/provider"
+)
+
+func TestProvider_Resolve(t *testing.T) {
+	reader := fake.NewClientBuilder().WithObjects(
+		&v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "shared",
+				Namespace:   "infra",
+				Annotations: map[string]string{AllowedNamespacesAnnotation: "app1, app2"},
+			},
+			Data: map[string][]byte{"password": []byte("secret")},
+		},
+		&v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "private",
+				Namespace: "infra",
+			},
+			Data: map[string][]byte{"password": []byte("secret")},
+		},
+		&v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "ca",
+				Namespace:   "infra",
+				Annotations: map[string]string{AllowedNamespacesAnnotation: "*"},
+			},
+			Data:       map[string]string{"ca.crt": "certificate"},
+			BinaryData: map[string][]byte{"ca.der": {0x30, 0x82}},
+		},
+	).Build()
+	registry := provider.NewRegistry()
+	Register(registry, reader, reader)
+
+	tests := map[string]struct {
+		namespace        string
+		ref              string
+		expected         []byte
+		expectedError    bool
+		expectedNotFound bool
+	}{
+		"resolve secret from allowed namespace": {
+			namespace: "app2",
+			ref:       "k8s-secret://infra/shared/password",
+			expected:  []byte("secret"),
+		},
+		"return error when namespace is not allowed": {
+			namespace:     "app3",
+			ref:           "k8s-secret://infra/shared/password",
+			expectedError: true,
+		},
+		"return error when secret is not annotated": {
+			namespace:     "app1",
+			ref:           "k8s-secret://infra/private/password",
+			expectedError: true,
+		},
+		"return not found error when key does not exist": {
+			namespace:        "app1",
+			ref:              "k8s-secret://infra/shared/username",
+			expectedError:    true,
+			expectedNotFound: true,
+		},
+		"return not found error when secret does not exist": {
+			namespace:        "app1",
+			ref:              "k8s-secret://infra/unknown/password",
+			expectedError:    true,
+			expectedNotFound: true,
+		},
+		"resolve configmap data": {
+			namespace: "app1",
+			ref:       "k8s-configmap://infra/ca/ca.crt",
+			expected:  []byte("certificate"),
+		},
+		"resolve configmap binary data": {
+			namespace: "app1",
+			ref:       "k8s-configmap://infra/ca/ca.der",
+			expected:  []byte{0x30, 0x82},
+		},
+		"resolve from cluster scoped object when all namespaces are allowed": {
+			namespace: "",
+			ref:       "k8s-configmap://infra/ca/ca.crt",
+			expected:  []byte("certificate"),
+		},
+	}
+
+	for n, tt := range tests {
+		t.Run(n, func(t *testing.T) {
+			ctx := provider.WithNamespace(context.Background(), tt.namespace)
+			got, err := registry.Resolve(ctx, tt.ref)
+			if (err != nil) != tt.expectedError {
+				t.Fatalf("expected error %v, but got %v", tt.expectedError, err)
+			}
+			if errors.Is(err, provider.ErrNotFound) != tt.expectedNotFound {
+				t.Errorf("expected not found %v, but got %v", tt.expectedNotFound, err)
+			}
+			if diff := cmp.Diff(tt.expected, got); diff != "" {
+				t.Errorf("Resolve result diff (-expect, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestProvider_configMapReaders(t *testing.T) {
+	configMap := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "ca",
+			Namespace:   "infra",
+			Annotations: map[string]string{AllowedNamespacesAnnotation: "*"},
+		},
+		Data: map[string]string{"ca.crt": "certificate"},
+	}
+	// The cache only has the metadata of ConfigMaps, so the data is read from the API server.
+	cached := configMap.DeepCopy()
+	cached.Data = nil
+	reader := fake.NewClientBuilder().WithObjects(cached).Build()
+	apiReader := fake.NewClientBuilder().WithObjects(configMap).Build()
+	registry := provider.NewRegistry()
+	Register(registry, reader, apiReader)
+
+	ctx := provider.WithNamespace(context.Background(), "app")
+	version, err := registry.Version(ctx, "k8s-configmap://infra/ca/ca.crt")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if version != cached.ResourceVersion {
+		t.Errorf("expected version %s, but got %s", cached.ResourceVersion, version)
+	}
+
+	got, err := registry.Resolve(ctx, "k8s-configmap://infra/ca/ca.crt")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff([]byte("certificate"), got); diff != "" {
+		t.Errorf("Resolve result diff (-expect, +got)\n%s", diff)
+	}
+}
+
+func TestParseReference(t *testing.T) {
+	tests := map[string]struct {
+		ref           string
+		expected      *Reference
+		expectedError bool
+	}{
+		"secret": {
+			ref:      "k8s-secret://infra/shared/password",
+			expected: &Reference{Scheme: SchemeSecret, Namespace: "infra", Name: "shared", Key: "password"},
+		},
+		"configmap": {
+			ref:      "k8s-configmap://infra/ca/ca.crt",
+			expected: &Reference{Scheme: SchemeConfigMap, Namespace: "infra", Name: "ca", Key: "ca.crt"},
+		},
+		"without key": {
+			ref:           "k8s-secret://infra/shared",
+			expectedError: true,
+		},
+		"empty name": {
+			ref:           "k8s-secret://infra//password",
+			expectedError: true,
+		},
+	}
+
+	for n, tt := range tests {
+		t.Run(n, func(t *testing.T) {
+			got, err := ParseReference(tt.ref)
+			if (err != nil) != tt.expectedError {
+				t.Fatalf("expected error %v, but got %v", tt.expectedError, err)
+			}
+			if diff := cmp.Diff(tt.expected, got); diff != "" {
+				t.Errorf("ParseReference result diff (-expect, +got)\n%s", diff)
+			}
+		})
+	}
+}
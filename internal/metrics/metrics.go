@@ -0,0 +1,158 @@
+// Package metrics provides the Prometheus metrics of secret syncing.
+// They are registered on the registry of controller-runtime, so that they are exposed by the metrics endpoint of the manager.
+package metrics
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/kitagry/berglas-secret-controller/internal/provider"
+)
+
+const namespace = "berglassecret"
+
+// Results of syncs.
+const (
+	ResultSuccess = "success"
+	ResultError   = "error"
+)
+
+// Outcomes of provider calls.
+const (
+	OutcomeSuccess  = "success"
+	OutcomeNotFound = "not_found"
+	OutcomeError    = "error"
+)
+
+var (
+	syncDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "sync_duration_seconds",
+		Help:      "Duration of syncing Secret, by the namespace of the resource and the result.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 12),
+	}, []string{"namespace", "result"})
+
+	providerCalls = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "provider_calls_total",
+		Help:      "Number of calls to the secret backends, by the provider, method, outcome and project. project is the bucket for Cloud Storage.",
+	}, []string{"provider", "method", "outcome", "project"})
+
+	propagationLag = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "propagation_lag_seconds",
+		Help:      "Time from the creation of a new version in the backend to the update of Secret, by the namespace of the resource.",
+		Buckets:   []float64{10, 30, 60, 120, 300, 600, 1200, 1800, 3600, 7200, 21600, 43200, 86400},
+	}, []string{"namespace"})
+
+	managedKeys = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "managed_keys",
+		Help:      "Number of keys in the Secret synced by the resource.",
+	}, []string{"kind", "namespace", "name"})
+
+	lastSuccess = newSinceCollector(prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "seconds_since_last_success"),
+		"Seconds since Secret was synced successfully by the resource.",
+		[]string{"kind", "namespace", "name"}, nil,
+	))
+)
+
+func init() {
+	metrics.Registry.MustRegister(syncDuration, providerCalls, propagationLag, managedKeys, lastSuccess)
+}
+
+// ObjectKey identifies a BerglasSecret or ClusterBerglasSecret. Namespace is empty for ClusterBerglasSecret.
+type ObjectKey struct {
+	Kind      string
+	Namespace string
+	Name      string
+}
+
+// ObserveSync records the sync of obj which started at start. keys is the number of keys in the synced Secret,
+// and it is negative when Secret isn't synced.
+func ObserveSync(obj ObjectKey, start time.Time, keys int, err error) {
+	result := ResultSuccess
+	if err != nil {
+		result = ResultError
+	}
+	syncDuration.WithLabelValues(obj.Namespace, result).Observe(time.Since(start).Seconds())
+	if keys >= 0 {
+		managedKeys.WithLabelValues(obj.Kind, obj.Namespace, obj.Name).Set(float64(keys))
+	}
+	if err == nil {
+		lastSuccess.set(obj, time.Now())
+	}
+}
+
+// ObservePropagationLag records the time from the creation of a new version to the update of Secret.
+func ObservePropagationLag(namespace string, lag time.Duration) {
+	propagationLag.WithLabelValues(namespace).Observe(lag.Seconds())
+}
+
+// Forget deletes the metrics of obj, which is deleted.
+func Forget(obj ObjectKey) {
+	managedKeys.DeleteLabelValues(obj.Kind, obj.Namespace, obj.Name)
+	lastSuccess.delete(obj)
+}
+
+// ObserveProviderCall records the call of method to provider with its error.
+// project is the project of the reference, or the bucket for Cloud Storage.
+func ObserveProviderCall(provider, method, project string, err error) {
+	providerCalls.WithLabelValues(provider, method, outcome(err), project).Inc()
+}
+
+func outcome(err error) string {
+	switch {
+	case err == nil:
+		return OutcomeSuccess
+	case errors.Is(err, provider.ErrNotFound):
+		return OutcomeNotFound
+	default:
+		return OutcomeError
+	}
+}
+
+// sinceCollector reports the seconds since the recorded times at the time of the scrape.
+type sinceCollector struct {
+	desc *prometheus.Desc
+	now  func() time.Time
+
+	mu    sync.Mutex
+	times map[ObjectKey]time.Time
+}
+
+var _ prometheus.Collector = &sinceCollector{}
+
+func newSinceCollector(desc *prometheus.Desc) *sinceCollector {
+	return &sinceCollector{desc: desc, now: time.Now, times: make(map[ObjectKey]time.Time)}
+}
+
+func (c *sinceCollector) set(obj ObjectKey, t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.times[obj] = t
+}
+
+func (c *sinceCollector) delete(obj ObjectKey) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.times, obj)
+}
+
+func (c *sinceCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *sinceCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	for obj, t := range c.times {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, now.Sub(t).Seconds(), obj.Kind, obj.Namespace, obj.Name)
+	}
+}
@@ -0,0 +1,64 @@
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/kitagry/berglas-secret-controller/internal/provider"
+)
+
+func TestOutcome(t *testing.T) {
+	tests := map[string]struct {
+		err      error
+		expected string
+	}{
+		"success": {
+			err:      nil,
+			expected: OutcomeSuccess,
+		},
+		"not found": {
+			err:      fmt.Errorf("failed to get secret version: %w", provider.NotFound(errors.New("not found"))),
+			expected: OutcomeNotFound,
+		},
+		"error": {
+			err:      errors.New("permission denied"),
+			expected: OutcomeError,
+		},
+	}
+
+	for n, tt := range tests {
+		t.Run(n, func(t *testing.T) {
+			got := outcome(tt.err)
+			if got != tt.expected {
+				t.Errorf("expected %s, but got %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestSinceCollector(t *testing.T) {
+	desc := prometheus.NewDesc("seconds_since_last_success", "help", []string{"kind", "namespace", "name"}, nil)
+	c := newSinceCollector(desc)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c.now = func() time.Time { return now }
+
+	obj := ObjectKey{Kind: "BerglasSecret", Namespace: "default", Name: "app"}
+	c.set(obj, now.Add(-90*time.Second))
+	c.set(ObjectKey{Kind: "BerglasSecret", Namespace: "default", Name: "deleted"}, now)
+	c.delete(ObjectKey{Kind: "BerglasSecret", Namespace: "default", Name: "deleted"})
+
+	expected := `
+# HELP seconds_since_last_success help
+# TYPE seconds_since_last_success gauge
+seconds_since_last_success{kind="BerglasSecret",name="app",namespace="default"} 90
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
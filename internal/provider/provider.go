@@ -0,0 +1,139 @@
+// Package provider defines secret backends and the registry which dispatches references to them by URI scheme.
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrUnsupportedReference is returned when a value is not a reference of any registered provider.
+// Such values are treated as literal values.
+var ErrUnsupportedReference = errors.New("unsupported reference")
+
+// ErrNotFound matches the errors of providers when the secret which a reference points to doesn't exist.
+var ErrNotFound = errors.New("not found")
+
+type notFoundError struct {
+	err error
+}
+
+func (e *notFoundError) Error() string {
+	return e.err.Error()
+}
+
+func (e *notFoundError) Unwrap() []error {
+	return []error{e.err, ErrNotFound}
+}
+
+// NotFound returns err which also matches ErrNotFound.
+func NotFound(err error) error {
+	return &notFoundError{err: err}
+}
+
+// Provider is a secret backend which resolves references like "scheme://...".
+type Provider interface {
+	// Resolve returns the plaintext of the secret which ref points to.
+	Resolve(ctx context.Context, ref string) ([]byte, error)
+	// Version returns an identifier which changes whenever the secret which ref points to is updated.
+	Version(ctx context.Context, ref string) (string, error)
+	// Validate checks the syntax of ref without accessing the backend.
+	Validate(ref string) error
+}
+
+// VersionCreateTimer is implemented by the providers whose versions have the time when they were created.
+type VersionCreateTimer interface {
+	// VersionCreateTime returns the time when version, which is returned by Version for ref, was created.
+	// ok is false when the time is unknown.
+	VersionCreateTime(ref, version string) (t time.Time, ok bool)
+}
+
+// Registry is a Provider which dispatches references to the provider registered for their scheme.
+// Providers should be registered before the Registry is used.
+type Registry struct {
+	providers map[string]Provider
+}
+
+var _ Provider = &Registry{}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{providers: make(map[string]Provider)}
+}
+
+// Register registers p for references which start with "<scheme>://".
+// It panics if a provider is already registered for the scheme.
+func (r *Registry) Register(scheme string, p Provider) {
+	if _, ok := r.providers[scheme]; ok {
+		panic(fmt.Sprintf("provider for %q is already registered", scheme))
+	}
+	r.providers[scheme] = p
+}
+
+func (r *Registry) Resolve(ctx context.Context, ref string) ([]byte, error) {
+	p, err := r.lookup(ref)
+	if err != nil {
+		return nil, err
+	}
+	return p.Resolve(ctx, ref)
+}
+
+func (r *Registry) Version(ctx context.Context, ref string) (string, error) {
+	p, err := r.lookup(ref)
+	if err != nil {
+		return "", err
+	}
+	return p.Version(ctx, ref)
+}
+
+// VersionCreateTime returns the time when version of ref was created, if the provider of ref implements VersionCreateTimer.
+func (r *Registry) VersionCreateTime(ref, version string) (time.Time, bool) {
+	p, err := r.lookup(ref)
+	if err != nil {
+		return time.Time{}, false
+	}
+	vt, ok := p.(VersionCreateTimer)
+	if !ok {
+		return time.Time{}, false
+	}
+	return vt.VersionCreateTime(ref, version)
+}
+
+// Validate returns ErrUnsupportedReference when ref is not a reference of registered providers.
+// Otherwise, it returns the result of the provider's Validate.
+func (r *Registry) Validate(ref string) error {
+	p, err := r.lookup(ref)
+	if err != nil {
+		return err
+	}
+	return p.Validate(ref)
+}
+
+func (r *Registry) lookup(ref string) (Provider, error) {
+	// Don't include ref in the error, because it may be a literal secret value.
+	scheme, _, ok := strings.Cut(ref, "://")
+	if !ok {
+		return nil, ErrUnsupportedReference
+	}
+	p, ok := r.providers[scheme]
+	if !ok {
+		return nil, ErrUnsupportedReference
+	}
+	return p, nil
+}
+
+type namespaceKey struct{}
+
+// WithNamespace returns a context which carries the namespace of the object whose references are resolved.
+// Providers use it to check whether the object is allowed to access the secret.
+func WithNamespace(ctx context.Context, namespace string) context.Context {
+	return context.WithValue(ctx, namespaceKey{}, namespace)
+}
+
+// NamespaceFrom returns the namespace set by WithNamespace.
+func NamespaceFrom(ctx context.Context) (string, bool) {
+	namespace, ok := ctx.Value(namespaceKey{}).(string)
+	return namespace, ok
+}
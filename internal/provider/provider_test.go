@@ -0,0 +1,132 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+type staticProvider struct {
+	value []byte
+}
+
+func (p *staticProvider) Resolve(ctx context.Context, ref string) ([]byte, error) {
+	return p.value, nil
+}
+
+func (p *staticProvider) Version(ctx context.Context, ref string) (string, error) {
+	return "1", nil
+}
+
+func (p *staticProvider) Validate(ref string) error {
+	return nil
+}
+
+func TestRegistry(t *testing.T) {
+	tests := map[string]struct {
+		ref           string
+		expected      []byte
+		expectedError error
+	}{
+		"dispatch to the provider of the scheme": {
+			ref:      "foo://bar",
+			expected: []byte("foo"),
+		},
+		"dispatch to another provider": {
+			ref:      "bar://foo",
+			expected: []byte("bar"),
+		},
+		"unknown scheme is unsupported": {
+			ref:           "baz://foo",
+			expectedError: ErrUnsupportedReference,
+		},
+		"literal value is unsupported": {
+			ref:           "value",
+			expectedError: ErrUnsupportedReference,
+		},
+	}
+
+	registry := NewRegistry()
+	registry.Register("foo", &staticProvider{value: []byte("foo")})
+	registry.Register("bar", &staticProvider{value: []byte("bar")})
+
+	for n, tt := range tests {
+		t.Run(n, func(t *testing.T) {
+			if err := registry.Validate(tt.ref); !errors.Is(err, tt.expectedError) {
+				t.Fatalf("expected error %v, but got %v", tt.expectedError, err)
+			}
+
+			got, err := registry.Resolve(context.Background(), tt.ref)
+			if !errors.Is(err, tt.expectedError) {
+				t.Fatalf("expected error %v, but got %v", tt.expectedError, err)
+			}
+			if diff := cmp.Diff(tt.expected, got); diff != "" {
+				t.Errorf("Resolve result diff (-expect, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+type timedProvider struct {
+	staticProvider
+}
+
+func (p *timedProvider) VersionCreateTime(ref, version string) (time.Time, bool) {
+	return time.Unix(100, 0), true
+}
+
+func TestRegistry_VersionCreateTime(t *testing.T) {
+	tests := map[string]struct {
+		ref        string
+		expected   time.Time
+		expectedOK bool
+	}{
+		"provider which knows the creation time": {
+			ref:        "timed://foo",
+			expected:   time.Unix(100, 0),
+			expectedOK: true,
+		},
+		"provider which doesn't know the creation time": {
+			ref:        "foo://bar",
+			expectedOK: false,
+		},
+		"literal value": {
+			ref:        "value",
+			expectedOK: false,
+		},
+	}
+
+	registry := NewRegistry()
+	registry.Register("foo", &staticProvider{value: []byte("foo")})
+	registry.Register("timed", &timedProvider{})
+
+	for n, tt := range tests {
+		t.Run(n, func(t *testing.T) {
+			got, ok := registry.VersionCreateTime(tt.ref, "1")
+			if ok != tt.expectedOK {
+				t.Fatalf("expected ok %v, but got %v", tt.expectedOK, ok)
+			}
+			if !got.Equal(tt.expected) {
+				t.Errorf("expected %v, but got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestNotFound(t *testing.T) {
+	cause := errors.New("secret does not exist")
+	err := NotFound(cause)
+
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected %v to match ErrNotFound", err)
+	}
+	if !errors.Is(err, cause) {
+		t.Errorf("expected %v to match the cause", err)
+	}
+	if diff := cmp.Diff(cause.Error(), err.Error()); diff != "" {
+		t.Errorf("NotFound message diff (-expect, +got)\n%s", diff)
+	}
+}
@@ -0,0 +1,180 @@
+// Package secrettemplate renders the templates of BerglasSecret with the resolved secret values.
+package secrettemplate
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// funcs is the set of helper functions available in templates.
+// It doesn't include functions which access the environment, such as env or file reads.
+var funcs = template.FuncMap{
+	"b64enc": func(s string) string {
+		return base64.StdEncoding.EncodeToString([]byte(s))
+	},
+	"b64dec": func(s string) (string, error) {
+		b, err := base64.StdEncoding.DecodeString(s)
+		return string(b), err
+	},
+	"toJson": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"indent": indent,
+	"nindent": func(spaces int, s string) string {
+		return "\n" + indent(spaces, s)
+	},
+	"quote": strconv.Quote,
+}
+
+func indent(spaces int, s string) string {
+	pad := strings.Repeat(" ", spaces)
+	return pad + strings.ReplaceAll(s, "\n", "\n"+pad)
+}
+
+// Parse parses text as a template.
+func Parse(name, text string) (*template.Template, error) {
+	return template.New(name).Funcs(funcs).Option("missingkey=error").Parse(text)
+}
+
+// Render renders each template with values. Templates refer to the values by the key, like {{ .password }}.
+func Render(templates map[string]string, values map[string][]byte) (map[string][]byte, error) {
+	data := make(map[string]string, len(values))
+	for key, value := range values {
+		data[key] = string(value)
+	}
+
+	result := make(map[string][]byte, len(templates))
+	for key, text := range templates {
+		b, err := execute(key, text, data)
+		if err != nil {
+			return nil, err
+		}
+		result[key] = b
+	}
+	return result, nil
+}
+
+// Validate checks that text can be parsed and only refers to keys, and returns the keys which text refers to.
+// The keys are collected from the parse tree, so that the keys in branches which are not taken are also checked.
+func Validate(text string, keys []string) ([]string, error) {
+	tmpl, err := Parse("template", text)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template: %w", err)
+	}
+
+	refs := make(map[string]struct{})
+	for _, t := range tmpl.Templates() {
+		if t.Tree != nil {
+			collectKeys(t.Tree.Root, true, refs)
+		}
+	}
+	for key := range refs {
+		if !slices.Contains(keys, key) {
+			return nil, fmt.Errorf("template refers to unknown key %q (available keys are %v)", key, slices.Sorted(slices.Values(keys)))
+		}
+	}
+	return slices.Sorted(maps.Keys(refs)), nil
+}
+
+// collectKeys adds the keys which node refers to into refs.
+// root reports whether dot is the values, which is not the case inside range and with.
+func collectKeys(node parse.Node, root bool, refs map[string]struct{}) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			collectKeys(child, root, refs)
+		}
+	case *parse.ActionNode:
+		collectKeys(n.Pipe, root, refs)
+	case *parse.TemplateNode:
+		collectKeys(n.Pipe, root, refs)
+	case *parse.IfNode:
+		collectKeys(n.Pipe, root, refs)
+		collectKeys(n.List, root, refs)
+		collectKeys(n.ElseList, root, refs)
+	case *parse.RangeNode:
+		collectKeys(n.Pipe, root, refs)
+		collectKeys(n.List, false, refs)
+		collectKeys(n.ElseList, root, refs)
+	case *parse.WithNode:
+		collectKeys(n.Pipe, root, refs)
+		collectKeys(n.List, false, refs)
+		collectKeys(n.ElseList, root, refs)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			collectKeys(cmd, root, refs)
+		}
+	case *parse.CommandNode:
+		if key, ok := indexKey(n, root); ok {
+			refs[key] = struct{}{}
+		}
+		for _, arg := range n.Args {
+			collectKeys(arg, root, refs)
+		}
+	case *parse.ChainNode:
+		collectKeys(n.Node, root, refs)
+	case *parse.FieldNode:
+		if root {
+			refs[n.Ident[0]] = struct{}{}
+		}
+	case *parse.VariableNode:
+		if n.Ident[0] == "$" && len(n.Ident) > 1 {
+			refs[n.Ident[1]] = struct{}{}
+		}
+	}
+}
+
+// indexKey returns the key of {{ index . "key" }} or {{ index $ "key" }}.
+func indexKey(cmd *parse.CommandNode, root bool) (string, bool) {
+	if len(cmd.Args) < 3 {
+		return "", false
+	}
+	if ident, ok := cmd.Args[0].(*parse.IdentifierNode); !ok || ident.Ident != "index" {
+		return "", false
+	}
+	switch values := cmd.Args[1].(type) {
+	case *parse.DotNode:
+		if !root {
+			return "", false
+		}
+	case *parse.VariableNode:
+		if len(values.Ident) != 1 || values.Ident[0] != "$" {
+			return "", false
+		}
+	default:
+		return "", false
+	}
+	key, ok := cmd.Args[2].(*parse.StringNode)
+	if !ok {
+		return "", false
+	}
+	return key.Text, true
+}
+
+func execute(name, text string, data map[string]string) ([]byte, error) {
+	tmpl, err := Parse(name, text)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("failed to render template (available keys are %v): %w", slices.Sorted(maps.Keys(data)), err)
+	}
+	return buf.Bytes(), nil
+}
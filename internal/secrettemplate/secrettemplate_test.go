@@ -0,0 +1,123 @@
+package secrettemplate
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRender(t *testing.T) {
+	tests := map[string]struct {
+		templates     map[string]string
+		values        map[string][]byte
+		expected      map[string][]byte
+		expectedError bool
+	}{
+		"render multiple values": {
+			templates: map[string]string{
+				"url": "jdbc:postgresql://{{ .host }}:5432/app?user={{ .user }}&password={{ .password }}",
+			},
+			values: map[string][]byte{
+				"host":     []byte("localhost"),
+				"user":     []byte("admin"),
+				"password": []byte("p@ss"),
+			},
+			expected: map[string][]byte{
+				"url": []byte("jdbc:postgresql://localhost:5432/app?user=admin&password=p@ss"),
+			},
+		},
+		"render with helper functions": {
+			templates: map[string]string{
+				".npmrc":           "//registry.npmjs.org/:_authToken={{ .token | quote }}",
+				"auth":             "{{ .token | b64enc }}",
+				"config.json":      `{"token":{{ .token | toJson }}}`,
+				"application.yaml": "db:{{ index . \"db.password\" | nindent 2 }}",
+			},
+			values: map[string][]byte{
+				"token":       []byte("abc"),
+				"db.password": []byte("p@ss"),
+			},
+			expected: map[string][]byte{
+				".npmrc":           []byte(`//registry.npmjs.org/:_authToken="abc"`),
+				"auth":             []byte("YWJj"),
+				"config.json":      []byte(`{"token":"abc"}`),
+				"application.yaml": []byte("db:\n  p@ss"),
+			},
+		},
+		"return error when key is unknown": {
+			templates: map[string]string{
+				"url": "{{ .unknown }}",
+			},
+			values:        map[string][]byte{},
+			expectedError: true,
+		},
+	}
+
+	for n, tt := range tests {
+		t.Run(n, func(t *testing.T) {
+			got, err := Render(tt.templates, tt.values)
+			if (err != nil) != tt.expectedError {
+				t.Fatalf("expected error %v, but got %v", tt.expectedError, err)
+			}
+			if diff := cmp.Diff(tt.expected, got); diff != "" {
+				t.Errorf("Render result diff (-expect, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := map[string]struct {
+		text          string
+		keys          []string
+		expected      []string
+		expectedError bool
+	}{
+		"valid template": {
+			text:     "{{ .user }}:{{ .password | b64enc }}",
+			keys:     []string{"user", "password", "host"},
+			expected: []string{"password", "user"},
+		},
+		"template which refers to keys with index and variables": {
+			text:     `{{ index . "db.password" }}{{ range $k, $v := .hosts }}{{ $.user }}{{ end }}`,
+			keys:     []string{"db.password", "hosts", "user"},
+			expected: []string{"db.password", "hosts", "user"},
+		},
+		"template which does not parse": {
+			text:          "{{ .user ",
+			keys:          []string{"user"},
+			expectedError: true,
+		},
+		"template which refers to unknown key": {
+			text:          "{{ .user }}:{{ .pass }}",
+			keys:          []string{"user", "password"},
+			expectedError: true,
+		},
+		"template which refers to unknown key in branch which is not taken": {
+			text:          "{{ if .user }}{{ .unknown }}{{ end }}",
+			keys:          []string{"user"},
+			expectedError: true,
+		},
+		"template which refers to unknown key with index": {
+			text:          `{{ index . "unknown" }}`,
+			keys:          []string{"user"},
+			expectedError: true,
+		},
+		"template which uses unknown function": {
+			text:          `{{ env "HOME" }}`,
+			expectedError: true,
+		},
+	}
+
+	for n, tt := range tests {
+		t.Run(n, func(t *testing.T) {
+			got, err := Validate(tt.text, tt.keys)
+			if (err != nil) != tt.expectedError {
+				t.Fatalf("expected error %v, but got %v", tt.expectedError, err)
+			}
+			if diff := cmp.Diff(tt.expected, got); diff != "" {
+				t.Errorf("Validate result diff (-expect, +got)\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+// Package tracing configures OpenTelemetry tracing of the controller and the webhook.
+//
+// Spans have the type of references and the keys of BerglasSecret as attributes, but never the values.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// ExporterNone disables tracing.
+	ExporterNone = "none"
+	// ExporterOTLP exports spans with OTLP over gRPC.
+	ExporterOTLP = "otlp"
+
+	serviceName = "berglas-secret-controller"
+	tracerName  = "github.com/kitagry/berglas-secret-controller"
+)
+
+// Attribute keys of the spans.
+const (
+	KeyAttribute           = attribute.Key("berglas.key")
+	ReferenceTypeAttribute = attribute.Key("berglas.reference.type")
+)
+
+// Options configures the exporter of the spans.
+type Options struct {
+	// Exporter is ExporterNone or ExporterOTLP.
+	Exporter string
+	// Endpoint is the host:port of the OTLP receiver.
+	// When it is empty, OTEL_EXPORTER_OTLP_TRACES_ENDPOINT or OTEL_EXPORTER_OTLP_ENDPOINT is used.
+	Endpoint string
+	// Insecure disables TLS of the connection to Endpoint.
+	Insecure bool
+}
+
+// DefaultExporter returns OTEL_TRACES_EXPORTER, or ExporterNone when it is not set.
+func DefaultExporter() string {
+	if exporter := os.Getenv("OTEL_TRACES_EXPORTER"); exporter != "" {
+		return exporter
+	}
+	return ExporterNone
+}
+
+// Setup installs the global TracerProvider for opts, and returns the function to flush and stop it.
+// The global TracerProvider is left as a no-op for ExporterNone.
+func Setup(ctx context.Context, opts Options) (shutdown func(context.Context) error, err error) {
+	switch opts.Exporter {
+	case ExporterNone, "":
+		return func(context.Context) error { return nil }, nil
+	case ExporterOTLP:
+	default:
+		return nil, fmt.Errorf("unsupported trace exporter %q, must be %s or %s", opts.Exporter, ExporterNone, ExporterOTLP)
+	}
+
+	var exporterOpts []otlptracegrpc.Option
+	if opts.Endpoint != "" {
+		exporterOpts = append(exporterOpts, otlptracegrpc.WithEndpoint(opts.Endpoint))
+	}
+	if opts.Insecure {
+		exporterOpts = append(exporterOpts, otlptracegrpc.WithInsecure())
+	}
+	exporter, err := otlptracegrpc.New(ctx, exporterOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+	}
+
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES take precedence over the default service name.
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName(serviceName)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create resource: %w", err)
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return tp.Shutdown, nil
+}
+
+// Start starts a span with attrs from the global TracerProvider.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End records err to span when it is not nil, and ends span.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Key returns the attribute of a key of BerglasSecret, such as a key of spec.data or dataFrom/<index>.
+func Key(key string) attribute.KeyValue {
+	return KeyAttribute.String(key)
+}
+
+// ReferenceType returns the attribute of the scheme of ref, such as sm or berglas.
+// The rest of ref is not recorded.
+func ReferenceType(ref string) attribute.KeyValue {
+	scheme, _, ok := strings.Cut(ref, "://")
+	if !ok {
+		scheme = ""
+	}
+	return ReferenceTypeAttribute.String(scheme)
+}
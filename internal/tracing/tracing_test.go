@@ -0,0 +1,120 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestReferenceType(t *testing.T) {
+	tests := map[string]struct {
+		ref      string
+		expected attribute.KeyValue
+	}{
+		"secret manager": {
+			ref:      "sm://project/secret#1",
+			expected: ReferenceTypeAttribute.String("sm"),
+		},
+		"storage": {
+			ref:      "berglas://bucket/object",
+			expected: ReferenceTypeAttribute.String("berglas"),
+		},
+		"not a reference": {
+			ref:      "password",
+			expected: ReferenceTypeAttribute.String(""),
+		},
+	}
+
+	for n, tt := range tests {
+		t.Run(n, func(t *testing.T) {
+			got := ReferenceType(tt.ref)
+			if diff := cmp.Diff(tt.expected, got, cmp.Comparer(func(a, b attribute.KeyValue) bool { return a == b })); diff != "" {
+				t.Errorf("ReferenceType result diff (-expect, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestEnd(t *testing.T) {
+	tests := map[string]struct {
+		err            error
+		expectedStatus sdktrace.Status
+		expectedEvents int
+	}{
+		"success": {
+			err:            nil,
+			expectedStatus: sdktrace.Status{Code: codes.Unset},
+			expectedEvents: 0,
+		},
+		"error": {
+			err:            errors.New("not found"),
+			expectedStatus: sdktrace.Status{Code: codes.Error, Description: "not found"},
+			expectedEvents: 1,
+		},
+	}
+
+	for n, tt := range tests {
+		t.Run(n, func(t *testing.T) {
+			recorder := tracetest.NewSpanRecorder()
+			tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+			_, span := tp.Tracer(tracerName).Start(context.Background(), "test")
+
+			End(span, tt.err)
+
+			spans := recorder.Ended()
+			if len(spans) != 1 {
+				t.Fatalf("expected 1 ended span, but got %d", len(spans))
+			}
+			if diff := cmp.Diff(tt.expectedStatus, spans[0].Status()); diff != "" {
+				t.Errorf("status diff (-expect, +got)\n%s", diff)
+			}
+			if got := len(spans[0].Events()); got != tt.expectedEvents {
+				t.Errorf("expected %d events, but got %d", tt.expectedEvents, got)
+			}
+		})
+	}
+}
+
+func TestSetup(t *testing.T) {
+	tests := map[string]struct {
+		opts      Options
+		expectErr bool
+	}{
+		"none": {
+			opts:      Options{Exporter: ExporterNone},
+			expectErr: false,
+		},
+		"empty": {
+			opts:      Options{},
+			expectErr: false,
+		},
+		"unsupported exporter": {
+			opts:      Options{Exporter: "zipkin"},
+			expectErr: true,
+		},
+	}
+
+	for n, tt := range tests {
+		t.Run(n, func(t *testing.T) {
+			shutdown, err := Setup(context.Background(), tt.opts)
+			if tt.expectErr {
+				if err == nil {
+					t.Fatal("expected error, but got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err := shutdown(context.Background()); err != nil {
+				t.Errorf("unexpected shutdown error: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package vault
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// DefaultServiceAccountTokenPath is the path of the token of the controller's ServiceAccount.
+	DefaultServiceAccountTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+	appRoleRoleIDKey   = "role_id"
+	appRoleSecretIDKey = "secret_id"
+)
+
+// Authenticator logs in to Vault.
+type Authenticator interface {
+	// Login returns the client token and its TTL.
+	Login(ctx context.Context, c *Client) (string, time.Duration, error)
+}
+
+// KubernetesAuth logs in with the Kubernetes auth method using the ServiceAccount token.
+type KubernetesAuth struct {
+	// Mount is the path where the auth method is mounted. Default is "kubernetes".
+	Mount string
+	// Role is the Vault role bound to the ServiceAccount.
+	Role string
+	// TokenPath is the path of the ServiceAccount token. Default is DefaultServiceAccountTokenPath.
+	TokenPath string
+}
+
+func (a *KubernetesAuth) Login(ctx context.Context, c *Client) (string, time.Duration, error) {
+	jwt, err := os.ReadFile(getOrDefault(a.TokenPath, DefaultServiceAccountTokenPath))
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to read service account token: %w", err)
+	}
+	return c.login(ctx, getOrDefault(a.Mount, "kubernetes"), map[string]string{
+		"role": a.Role,
+		"jwt":  string(jwt),
+	})
+}
+
+// AppRoleAuth logs in with the AppRole auth method.
+// The role_id and secret_id are read from the Secret on every login, so that they can be rotated.
+type AppRoleAuth struct {
+	// Mount is the path where the auth method is mounted. Default is "approle".
+	Mount string
+	// Reader reads the Secret.
+	Reader client.Reader
+	// Secret is the Secret which has role_id and secret_id keys.
+	Secret types.NamespacedName
+}
+
+func (a *AppRoleAuth) Login(ctx context.Context, c *Client) (string, time.Duration, error) {
+	var secret v1.Secret
+	if err := a.Reader.Get(ctx, a.Secret, &secret); err != nil {
+		return "", 0, fmt.Errorf("failed to get approle secret %s: %w", a.Secret, err)
+	}
+	roleID, ok := secret.Data[appRoleRoleIDKey]
+	if !ok {
+		return "", 0, fmt.Errorf("approle secret %s doesn't have %s", a.Secret, appRoleRoleIDKey)
+	}
+	return c.login(ctx, getOrDefault(a.Mount, "approle"), map[string]string{
+		"role_id":   string(roleID),
+		"secret_id": string(secret.Data[appRoleSecretIDKey]),
+	})
+}
+
+func getOrDefault(s, defaultValue string) string {
+	if s == "" {
+		return defaultValue
+	}
+	return s
+}
@@ -0,0 +1,317 @@
+// Package vault provides the provider for secrets stored in HashiCorp Vault KV version 2.
+package vault
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kitagry/berglas-secret-controller/internal/provider"
+)
+
+const (
+	// Scheme is the scheme of vault://<mount>/<path>#<field> references.
+	Scheme = "vault"
+
+	// tokenExpiryMargin is subtracted from the token TTL so that the token is renewed before it expires.
+	tokenExpiryMargin = 30 * time.Second
+)
+
+// ErrNotConfigured is returned for vault:// references when the address of Vault is not configured.
+var ErrNotConfigured = errors.New("vault provider is not configured (--vault-address)")
+
+// Config is the configuration of Client.
+type Config struct {
+	// Address is the address of the Vault server, e.g. https://vault.example.com:8200.
+	Address string
+	// Namespace is the Vault Enterprise namespace. It is optional.
+	Namespace string
+	// Auth logs in to Vault and returns the client token.
+	Auth Authenticator
+	// HTTPClient is used for requests to Vault. http.DefaultClient is used when it is nil.
+	HTTPClient *http.Client
+}
+
+// Client is the provider for vault:// references.
+// It resolves secrets through the HTTP API of Vault KV version 2.
+type Client struct {
+	address    string
+	namespace  string
+	auth       Authenticator
+	httpClient *http.Client
+
+	mu          sync.Mutex
+	token       string
+	tokenExpiry time.Time
+}
+
+var _ provider.Provider = &Client{}
+
+// New returns a Client. It doesn't log in to Vault until the first request.
+func New(cfg Config) (*Client, error) {
+	if cfg.Address == "" {
+		return nil, errors.New("vault address is required")
+	}
+	if cfg.Auth == nil {
+		return nil, errors.New("vault auth is required")
+	}
+	httpClient := cfg.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Client{
+		address:    strings.TrimSuffix(cfg.Address, "/"),
+		namespace:  cfg.Namespace,
+		auth:       cfg.Auth,
+		httpClient: httpClient,
+	}, nil
+}
+
+// Register registers c to r.
+func (c *Client) Register(r *provider.Registry) {
+	r.Register(Scheme, c)
+}
+
+// RegisterNotConfigured registers the provider which fails all vault:// references with ErrNotConfigured to r.
+// Without it, the references are not recognized and stored in Secrets as literal values.
+func RegisterNotConfigured(r *provider.Registry) {
+	r.Register(Scheme, notConfigured{})
+}
+
+// notConfigured is the provider for vault:// references when the address of Vault is not configured.
+type notConfigured struct{}
+
+var _ provider.Provider = notConfigured{}
+
+func (notConfigured) Resolve(ctx context.Context, ref string) ([]byte, error) {
+	return nil, ErrNotConfigured
+}
+
+func (notConfigured) Version(ctx context.Context, ref string) (string, error) {
+	return "", ErrNotConfigured
+}
+
+func (notConfigured) Validate(ref string) error {
+	_, err := ParseReference(ref)
+	return err
+}
+
+func (c *Client) Resolve(ctx context.Context, ref string) ([]byte, error) {
+	r, err := ParseReference(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp struct {
+		Data struct {
+			Data map[string]any `json:"data"`
+		} `json:"data"`
+	}
+	if err := c.read(ctx, fmt.Sprintf("/v1/%s/data/%s", r.Mount, r.Path), &resp); err != nil {
+		return nil, fmt.Errorf("failed to read secret %s: %w", ref, err)
+	}
+
+	if r.Field == "" {
+		return json.Marshal(resp.Data.Data)
+	}
+
+	value, ok := resp.Data.Data[r.Field]
+	if !ok {
+		return nil, provider.NotFound(fmt.Errorf("field %q is not found in secret %s", r.Field, ref))
+	}
+	if s, ok := value.(string); ok {
+		return []byte(s), nil
+	}
+	return json.Marshal(value)
+}
+
+// Version returns the current_version of the KV metadata.
+func (c *Client) Version(ctx context.Context, ref string) (string, error) {
+	r, err := ParseReference(ref)
+	if err != nil {
+		return "", err
+	}
+
+	var resp struct {
+		Data struct {
+			CurrentVersion int `json:"current_version"`
+		} `json:"data"`
+	}
+	if err := c.read(ctx, fmt.Sprintf("/v1/%s/metadata/%s", r.Mount, r.Path), &resp); err != nil {
+		return "", fmt.Errorf("failed to read metadata of secret %s: %w", ref, err)
+	}
+	return strconv.Itoa(resp.Data.CurrentVersion), nil
+}
+
+func (c *Client) Validate(ref string) error {
+	_, err := ParseReference(ref)
+	return err
+}
+
+// Reference is a parsed vault://<mount>/<path>#<field> reference.
+type Reference struct {
+	// Mount is the path where the KV secrets engine is mounted.
+	Mount string
+	// Path is the path of the secret in the mount.
+	Path string
+	// Field is the key in the secret. When it is empty, the whole secret is resolved as JSON.
+	Field string
+}
+
+// ParseReference parses vault://<mount>/<path>#<field>.
+func ParseReference(s string) (*Reference, error) {
+	rest, ok := strings.CutPrefix(s, Scheme+"://")
+	if !ok {
+		return nil, fmt.Errorf("reference %s doesn't start with %s://", s, Scheme)
+	}
+
+	rest, field, _ := strings.Cut(rest, "#")
+	mount, path, ok := strings.Cut(rest, "/")
+	path = strings.Trim(path, "/")
+	if !ok || mount == "" || path == "" {
+		return nil, fmt.Errorf("reference %s should be vault://<mount>/<path>#<field>", s)
+	}
+	return &Reference{Mount: mount, Path: path, Field: field}, nil
+}
+
+// read sends GET request to path and decodes the response into v.
+// When the token is rejected, it logs in again and retries once.
+func (c *Client) read(ctx context.Context, path string, v any) error {
+	token, err := c.getToken(ctx, false)
+	if err != nil {
+		return err
+	}
+
+	err = c.do(ctx, http.MethodGet, path, token, nil, v)
+	var respErr *ResponseError
+	if errors.As(err, &respErr) && respErr.StatusCode == http.StatusForbidden {
+		token, err = c.getToken(ctx, true)
+		if err != nil {
+			return err
+		}
+		err = c.do(ctx, http.MethodGet, path, token, nil, v)
+	}
+	return err
+}
+
+func (c *Client) getToken(ctx context.Context, force bool) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !force && c.token != "" && (c.tokenExpiry.IsZero() || time.Now().Before(c.tokenExpiry)) {
+		return c.token, nil
+	}
+
+	token, ttl, err := c.auth.Login(ctx, c)
+	if err != nil {
+		return "", fmt.Errorf("failed to login to vault: %w", err)
+	}
+	c.token = token
+	c.tokenExpiry = tokenExpiry(time.Now(), ttl)
+	return token, nil
+}
+
+// tokenExpiry returns the time when the token issued at now with ttl should be renewed.
+// The zero time is returned for ttl 0, which means the token never expires.
+func tokenExpiry(now time.Time, ttl time.Duration) time.Time {
+	switch {
+	case ttl <= 0:
+		return time.Time{}
+	case ttl <= tokenExpiryMargin:
+		return now.Add(ttl / 2)
+	default:
+		return now.Add(ttl - tokenExpiryMargin)
+	}
+}
+
+// login sends the login request to the auth method mounted at mount.
+func (c *Client) login(ctx context.Context, mount string, body any) (string, time.Duration, error) {
+	var resp struct {
+		Auth struct {
+			ClientToken   string `json:"client_token"`
+			LeaseDuration int    `json:"lease_duration"`
+		} `json:"auth"`
+	}
+	if err := c.do(ctx, http.MethodPost, fmt.Sprintf("/v1/auth/%s/login", mount), "", body, &resp); err != nil {
+		return "", 0, err
+	}
+	if resp.Auth.ClientToken == "" {
+		return "", 0, errors.New("login response doesn't contain client token")
+	}
+	return resp.Auth.ClientToken, time.Duration(resp.Auth.LeaseDuration) * time.Second, nil
+}
+
+// ResponseError is returned when Vault responds with non 2xx status.
+type ResponseError struct {
+	StatusCode int
+	Errors     []string
+}
+
+// Is reports whether the secret is not found, so that the error matches provider.ErrNotFound.
+func (e *ResponseError) Is(target error) bool {
+	return target == provider.ErrNotFound && e.StatusCode == http.StatusNotFound
+}
+
+func (e *ResponseError) Error() string {
+	if len(e.Errors) == 0 {
+		return fmt.Sprintf("vault responded with status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("vault responded with status %d: %s", e.StatusCode, strings.Join(e.Errors, ", "))
+}
+
+func (c *Client) do(ctx context.Context, method, path, token string, body, v any) error {
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(b)
+	}
+
+	u, err := url.JoinPath(c.address, path)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, reqBody)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if c.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", c.namespace)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		respErr := &ResponseError{StatusCode: resp.StatusCode}
+		var errResp struct {
+			Errors []string `json:"errors"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&errResp); err == nil {
+			respErr.Errors = errResp.Errors
+		}
+		return respErr
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
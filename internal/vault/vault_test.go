@@ -0,0 +1,363 @@
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/kitagry/berglas-secret-controller/internal/provider"
+)
+
+// fakeVault is an in-process stand-in of the Vault HTTP API.
+// It supports Kubernetes and AppRole logins and KV version 2 read of the "secret" mount.
+type fakeVault struct {
+	mu      sync.Mutex
+	secrets map[string]map[string]any
+	version map[string]int
+	tokens  map[string]bool
+	logins  int
+	// leaseDuration is the TTL of the issued tokens in seconds.
+	leaseDuration int
+}
+
+func newFakeVault() *fakeVault {
+	return &fakeVault{
+		secrets: map[string]map[string]any{
+			"app/db": {"username": "admin", "password": "p@ss", "port": 5432},
+		},
+		version:       map[string]int{"app/db": 3},
+		tokens:        map[string]bool{},
+		leaseDuration: 3600,
+	}
+}
+
+func (f *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case r.Method == http.MethodPost && (r.URL.Path == "/v1/auth/kubernetes/login" || r.URL.Path == "/v1/auth/approle/login"):
+		var body map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		if body["jwt"] != "sa-token" && (body["role_id"] != "role" || body["secret_id"] != "secret") {
+			writeJSON(w, http.StatusBadRequest, map[string]any{"errors": []string{"invalid credentials"}})
+			return
+		}
+		f.logins++
+		token := fmt.Sprintf("token-%d", f.logins)
+		f.tokens[token] = true
+		writeJSON(w, http.StatusOK, map[string]any{"auth": map[string]any{"client_token": token, "lease_duration": f.leaseDuration}})
+	case strings.HasPrefix(r.URL.Path, "/v1/secret/data/"):
+		if !f.tokens[r.Header.Get("X-Vault-Token")] {
+			writeJSON(w, http.StatusForbidden, map[string]any{"errors": []string{"permission denied"}})
+			return
+		}
+		data, ok := f.secrets[strings.TrimPrefix(r.URL.Path, "/v1/secret/data/")]
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]any{"errors": []string{}})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"data": map[string]any{"data": data}})
+	case strings.HasPrefix(r.URL.Path, "/v1/secret/metadata/"):
+		if !f.tokens[r.Header.Get("X-Vault-Token")] {
+			writeJSON(w, http.StatusForbidden, map[string]any{"errors": []string{"permission denied"}})
+			return
+		}
+		version, ok := f.version[strings.TrimPrefix(r.URL.Path, "/v1/secret/metadata/")]
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]any{"errors": []string{}})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"data": map[string]any{"current_version": version}})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (f *fakeVault) revokeTokens() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.tokens = map[string]bool{}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func newTestClient(t *testing.T, vault *fakeVault) *Client {
+	t.Helper()
+	server := httptest.NewServer(vault)
+	t.Cleanup(server.Close)
+
+	tokenPath := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenPath, []byte("sa-token"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	c, err := New(Config{
+		Address:    server.URL,
+		Auth:       &KubernetesAuth{Role: "controller", TokenPath: tokenPath},
+		HTTPClient: server.Client(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestClient_Resolve(t *testing.T) {
+	tests := map[string]struct {
+		ref              string
+		expected         []byte
+		expectedError    bool
+		expectedNotFound bool
+	}{
+		"resolve string field": {
+			ref:      "vault://secret/app/db#password",
+			expected: []byte("p@ss"),
+		},
+		"resolve non string field as JSON": {
+			ref:      "vault://secret/app/db#port",
+			expected: []byte("5432"),
+		},
+		"resolve whole secret as JSON": {
+			ref:      "vault://secret/app/db",
+			expected: []byte(`{"password":"p@ss","port":5432,"username":"admin"}`),
+		},
+		"return not found error when field does not exist": {
+			ref:              "vault://secret/app/db#token",
+			expectedError:    true,
+			expectedNotFound: true,
+		},
+		"return not found error when secret does not exist": {
+			ref:              "vault://secret/app/cache#password",
+			expectedError:    true,
+			expectedNotFound: true,
+		},
+	}
+
+	c := newTestClient(t, newFakeVault())
+	for n, tt := range tests {
+		t.Run(n, func(t *testing.T) {
+			got, err := c.Resolve(context.Background(), tt.ref)
+			if (err != nil) != tt.expectedError {
+				t.Fatalf("expected error %v, but got %v", tt.expectedError, err)
+			}
+			if errors.Is(err, provider.ErrNotFound) != tt.expectedNotFound {
+				t.Errorf("expected not found %v, but got %v", tt.expectedNotFound, err)
+			}
+			if diff := cmp.Diff(tt.expected, got); diff != "" {
+				t.Errorf("Resolve result diff (-expect, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestClient_Version(t *testing.T) {
+	vault := newFakeVault()
+	c := newTestClient(t, vault)
+
+	got, err := c.Version(context.Background(), "vault://secret/app/db#password")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != "3" {
+		t.Errorf("expected version 3, but got %s", got)
+	}
+
+	vault.mu.Lock()
+	vault.version["app/db"] = 4
+	vault.mu.Unlock()
+
+	got, err = c.Version(context.Background(), "vault://secret/app/db#password")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != "4" {
+		t.Errorf("expected version 4, but got %s", got)
+	}
+}
+
+func TestClient_relogin(t *testing.T) {
+	vault := newFakeVault()
+	c := newTestClient(t, vault)
+
+	if _, err := c.Resolve(context.Background(), "vault://secret/app/db#password"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := c.Resolve(context.Background(), "vault://secret/app/db#password"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if vault.logins != 1 {
+		t.Errorf("token should be cached, but logged in %d times", vault.logins)
+	}
+
+	vault.revokeTokens()
+	if _, err := c.Resolve(context.Background(), "vault://secret/app/db#password"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if vault.logins != 2 {
+		t.Errorf("should log in again after the token is revoked, but logged in %d times", vault.logins)
+	}
+}
+
+func TestClient_tokenTTL(t *testing.T) {
+	tests := map[string]struct {
+		leaseDuration int
+	}{
+		"token which never expires": {
+			leaseDuration: 0,
+		},
+		"token whose ttl is shorter than the margin": {
+			leaseDuration: 10,
+		},
+	}
+
+	for n, tt := range tests {
+		t.Run(n, func(t *testing.T) {
+			vault := newFakeVault()
+			vault.leaseDuration = tt.leaseDuration
+			c := newTestClient(t, vault)
+
+			for range 2 {
+				if _, err := c.Resolve(context.Background(), "vault://secret/app/db#password"); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+			if vault.logins != 1 {
+				t.Errorf("token should be cached, but logged in %d times", vault.logins)
+			}
+		})
+	}
+}
+
+func TestTokenExpiry(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := map[string]struct {
+		ttl      time.Duration
+		expected time.Time
+	}{
+		"no expiry": {
+			ttl:      0,
+			expected: time.Time{},
+		},
+		"shorter than the margin": {
+			ttl:      10 * time.Second,
+			expected: now.Add(5 * time.Second),
+		},
+		"longer than the margin": {
+			ttl:      time.Hour,
+			expected: now.Add(time.Hour - tokenExpiryMargin),
+		},
+	}
+
+	for n, tt := range tests {
+		t.Run(n, func(t *testing.T) {
+			got := tokenExpiry(now, tt.ttl)
+			if !got.Equal(tt.expected) {
+				t.Errorf("expected %v, but got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestAppRoleAuth(t *testing.T) {
+	server := httptest.NewServer(newFakeVault())
+	defer server.Close()
+
+	reader := fake.NewClientBuilder().WithObjects(&v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "vault-approle", Namespace: "system"},
+		Data: map[string][]byte{
+			"role_id":   []byte("role"),
+			"secret_id": []byte("secret"),
+		},
+	}).Build()
+	c, err := New(Config{
+		Address: server.URL,
+		Auth: &AppRoleAuth{
+			Reader: reader,
+			Secret: types.NamespacedName{Namespace: "system", Name: "vault-approle"},
+		},
+		HTTPClient: server.Client(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := c.Resolve(context.Background(), "vault://secret/app/db#username")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(got) != "admin" {
+		t.Errorf("expected admin, but got %s", got)
+	}
+}
+
+func TestParseReference(t *testing.T) {
+	tests := map[string]struct {
+		ref           string
+		expected      *Reference
+		expectedError bool
+	}{
+		"with field": {
+			ref:      "vault://secret/app/db#password",
+			expected: &Reference{Mount: "secret", Path: "app/db", Field: "password"},
+		},
+		"without field": {
+			ref:      "vault://kv/db",
+			expected: &Reference{Mount: "kv", Path: "db"},
+		},
+		"without path": {
+			ref:           "vault://secret#password",
+			expectedError: true,
+		},
+		"other scheme": {
+			ref:           "sm://project/secret",
+			expectedError: true,
+		},
+	}
+
+	for n, tt := range tests {
+		t.Run(n, func(t *testing.T) {
+			got, err := ParseReference(tt.ref)
+			if (err != nil) != tt.expectedError {
+				t.Fatalf("expected error %v, but got %v", tt.expectedError, err)
+			}
+			if diff := cmp.Diff(tt.expected, got); diff != "" {
+				t.Errorf("ParseReference result diff (-expect, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRegisterNotConfigured(t *testing.T) {
+	r := provider.NewRegistry()
+	RegisterNotConfigured(r)
+
+	// The reference must be recognized, otherwise it is stored as a literal value.
+	if err := r.Validate("vault://secret/app/db#password"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := r.Resolve(context.Background(), "vault://secret/app/db#password"); !errors.Is(err, ErrNotConfigured) {
+		t.Errorf("expected ErrNotConfigured, but got %v", err)
+	}
+	if _, err := r.Version(context.Background(), "vault://secret/app/db#password"); !errors.Is(err, ErrNotConfigured) {
+		t.Errorf("expected ErrNotConfigured, but got %v", err)
+	}
+}